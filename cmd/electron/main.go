@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/kubenav/kubenav/pkg/api"
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/metrics"
+	"github.com/kubenav/kubenav/pkg/server"
 	"github.com/kubenav/kubenav/pkg/version"
 
 	"github.com/asticode/go-astikit"
@@ -28,7 +31,9 @@ var (
 
 var (
 	fs                    = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cacheFlag             bool
 	debugFlag             bool
+	impersonationFlag     bool
 	kubeconfigFlag        string
 	kubeconfigIncludeFlag string
 	kubeconfigExcludeFlag string
@@ -46,7 +51,9 @@ type Message struct {
 var messageChannel = make(chan Message)
 
 func init() {
+	fs.BoolVar(&cacheFlag, "cache", false, "Serve list and get requests for common resources from an informer cache.")
 	fs.BoolVar(&debugFlag, "debug", false, "Enable debug mode.")
+	fs.BoolVar(&impersonationFlag, "impersonation", false, "Allow requests to impersonate other users and groups.")
 	fs.StringVar(&kubeconfigFlag, "kubeconfig", "", "Optional Kubeconfig file.")
 	fs.StringVar(&kubeconfigIncludeFlag, "kubeconfig.include", "", "Comma separated list of globs to include in the Kubeconfig.")
 	fs.StringVar(&kubeconfigExcludeFlag, "kubeconfig.exclude", "", "Comma separated list of globs to exclude from the Kubeconfig. This flag must be used in combination with the '--kubeconfig.include' flag.")
@@ -79,19 +86,30 @@ func main() {
 	log.WithFields(version.Info()).Infof("Version information")
 	log.WithFields(version.BuildContext()).Infof("Build context")
 
+	if err := metrics.Register(); err != nil {
+		log.WithError(err).Fatalf("Could not register metrics")
+	}
+
 	// Create the client for the interaction with the Kubernetes API.
-	kubeClient, err := kube.NewClient(false, false, kubeconfigFlag, kubeconfigIncludeFlag, kubeconfigExcludeFlag)
+	kubeClient, err := kube.NewClient(false, false, kubeconfigFlag, kubeconfigIncludeFlag, kubeconfigExcludeFlag, cacheFlag)
 	if err != nil {
 		log.WithError(err).Fatalf("Could not create Kubernetes client")
 	}
 
 	// Register the API routes for the Electron app. Additional to the devserver we need another rout to handle the
 	// communication between the Electron menu and the frontend via Server Sent Events. We also have to serve the
-	// frontend from the embedded assets.
+	// frontend from the embedded assets. The server is only listening on the loopback interface, because it is only
+	// used by the Electron window. When the app is closed, the server is shut down via the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan struct{})
+
 	go func() {
+		defer close(serverDone)
+
 		router := http.NewServeMux()
-		apiClient := api.NewClient(syncFlag, nil, kubeClient)
+		apiClient := api.NewClient(syncFlag, impersonationFlag, nil, nil, nil, nil, kubeClient)
 		apiClient.Register(router)
+		apiClient.RegisterMetrics(router, metrics.Handler())
 
 		// Add route for Server Sent Events. The events are handled via the message channel. Possible events are
 		// "navigation" and "cluster". These events are handled by the frontend to navigate to another page or to modify
@@ -144,7 +162,12 @@ func main() {
 			fmt.Fprintf(w, string(index))
 		})
 
-		if err := http.ListenAndServe(":14122", router); err != nil {
+		// The server is started via the server package, so that expired terminal, log and watch sessions are removed in
+		// the background.
+		config := server.DefaultConfig
+		config.Address = "localhost:14122"
+
+		if err := server.Run(ctx, config, router); err != nil {
 			log.WithError(err).Fatalf("kubenav server died")
 		}
 	}()
@@ -189,4 +212,8 @@ func main() {
 	}); err != nil {
 		log.WithError(err).Fatalf("Running kubenav failed")
 	}
+
+	cancel()
+	<-serverDone
+	kubeClient.Close()
 }
@@ -1,10 +1,12 @@
 package mobile
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/kubenav/kubenav/pkg/api"
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/server"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,16 +16,17 @@ import (
 // client we only have to set the isMobile argument to true, all other arguments are not needed for the mobile
 //implementation. Therefore we do not check for a returned error, because the initialization of the Kubernetes API
 // client for mobile can not return an error. When the router and Kubernetes API client is created, we are creating a
-// new API client and register all API routes. Finally we start the server which always listen on port 14122.
+// new API client and register all API routes. Impersonation is always allowed on mobile, because the requests are made
+// with the credentials of the user. Finally we start the server which always listen on port 14122.
 func StartServer() {
 	log.SetLevel(log.FatalLevel)
 
 	router := http.NewServeMux()
-	kubeClient, _ := kube.NewClient(true, false, "", "", "")
-	apiClient := api.NewClient(false, nil, kubeClient)
+	kubeClient, _ := kube.NewClient(true, false, "", "", "", false)
+	apiClient := api.NewClient(false, true, nil, nil, nil, nil, kubeClient)
 	apiClient.Register(router)
 
-	if err := http.ListenAndServe(":14122", router); err != nil {
+	if err := server.Run(context.Background(), server.DefaultConfig, router); err != nil {
 		return
 	}
 }
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kubenav/kubenav/pkg/api"
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
+	"github.com/kubenav/kubenav/pkg/handlers/files"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins/elasticsearch"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins/jaeger"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins/prometheus"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/metrics"
+	"github.com/kubenav/kubenav/pkg/server"
 	"github.com/kubenav/kubenav/pkg/version"
 
 	log "github.com/sirupsen/logrus"
@@ -23,10 +33,38 @@ import (
 
 var (
 	fs                                  = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	auditFileFlag                       string
+	auditSinksFlag                      []string
+	auditTranscriptsDirFlag             string
+	auditWebhookURLFlag                 string
+	authFlag                            string
+	authAdminGroupsFlag                 []string
+	authHeaderGroupsFlag                string
+	authHeaderTrustedProxiesFlag        string
+	authHeaderUserFlag                  string
+	authOIDCCertificateAuthorityFlag    string
+	authOIDCClientIDFlag                string
+	authOIDCClientSecretFlag            string
+	authOIDCGroupsClaimFlag             string
+	authOIDCIssuerURLFlag               string
+	authOIDCRedirectURLFlag             string
+	authOIDCScopesFlag                  string
+	authOIDCUsernameClaimFlag           string
+	authTokenFileFlag                   string
+	cacheFlag                           bool
+	corsAllowCredentialsFlag            bool
+	corsAllowedHeadersFlag              []string
+	corsAllowedMethodsFlag              []string
+	corsAllowedOriginsFlag              []string
+	corsConfigFlag                      string
+	corsMaxAgeFlag                      int
 	debugFlag                           bool
 	debugIonicFlag                      string
+	filesMaxSizeFlag                    int64
+	impersonationFlag                   bool
 	inclusterFlag                       bool
 	kubeconfigFlag                      string
+	listenAddressFlag                   string
 	pluginElasticsearchAddressFlag      string
 	pluginElasticsearchEnabledFlag      bool
 	pluginElasticsearchPasswordFlag     string
@@ -40,10 +78,26 @@ var (
 	pluginPrometheusEnabledFlag         bool
 	pluginPrometheusPasswordFlag        string
 	pluginPrometheusUsernameFlag        string
+	recordingsDirFlag                   string
+	serverIdleTimeoutFlag               time.Duration
+	serverReadTimeoutFlag               time.Duration
+	serverShutdownTimeoutFlag           time.Duration
+	serverWriteTimeoutFlag              time.Duration
+	sessionBindTimeoutFlag              time.Duration
+	sessionIdleTimeoutFlag              time.Duration
+	sessionMaxLifetimeFlag              time.Duration
 	showVersion                         bool
+	tlsCertFileFlag                     string
+	tlsClientCAFileFlag                 string
+	tlsKeyFileFlag                      string
 )
 
 func init() {
+	var defaultAuthOIDCClientSecretFlag string
+	if os.Getenv("KUBENAV_OIDC_CLIENT_SECRET") != "" {
+		defaultAuthOIDCClientSecretFlag = os.Getenv("KUBENAV_OIDC_CLIENT_SECRET")
+	}
+
 	var defaultPluginPrometheusUsernameFlag string
 	if os.Getenv("KUBENAV_PROMETHEUS_USERNAME") == "" {
 		defaultPluginPrometheusUsernameFlag = os.Getenv("KUBENAV_PROMETHEUS_USERNAME")
@@ -74,10 +128,38 @@ func init() {
 		defaultPluginJaegerPasswordFlag = os.Getenv("KUBENAV_JAEGER_PASSWORD")
 	}
 
+	fs.StringVar(&auditFileFlag, "audit.file", "", "Path to the file, where the audit events are written to as JSON lines.")
+	fs.StringSliceVar(&auditSinksFlag, "audit.sinks", nil, "Comma separated list of sinks for the audit log. Must be \"file\", \"stdout\" or \"webhook\".")
+	fs.StringVar(&auditTranscriptsDirFlag, "audit.transcripts-dir", "", "Directory, where the output of all terminal sessions is saved. If empty, no transcripts are saved.")
+	fs.StringVar(&auditWebhookURLFlag, "audit.webhook-url", "", "URL of the webhook, which receives all audit events via a POST request.")
+	fs.StringVar(&authFlag, "auth", "", "Authentication method for the API. Must be \"token\", \"header\" or \"oidc\". The authenticated user is passed to the Kubernetes API via impersonation.")
+	fs.StringSliceVar(&authAdminGroupsFlag, "auth.admin-groups", nil, "Comma separated list of groups, which can see and terminate the sessions of all users.")
+	fs.StringVar(&authHeaderGroupsFlag, "auth.header.groups", "X-Forwarded-Groups", "Header which contains the comma separated groups of the user.")
+	fs.StringVar(&authHeaderTrustedProxiesFlag, "auth.header.trusted-proxies", "", "Comma separated list of CIDRs, from which the headers are accepted. At least one CIDR is required for the header authenticator.")
+	fs.StringVar(&authHeaderUserFlag, "auth.header.user", "X-Forwarded-User", "Header which contains the name of the user.")
+	fs.StringVar(&authOIDCCertificateAuthorityFlag, "auth.oidc.certificate-authority", "", "Path to the certificate authority of the OIDC provider.")
+	fs.StringVar(&authOIDCClientIDFlag, "auth.oidc.client-id", "", "The client id for the OIDC provider.")
+	fs.StringVar(&authOIDCClientSecretFlag, "auth.oidc.client-secret", defaultAuthOIDCClientSecretFlag, "The client secret for the OIDC provider.")
+	fs.StringVar(&authOIDCGroupsClaimFlag, "auth.oidc.groups-claim", "groups", "The claim of the ID token, which contains the groups of the user.")
+	fs.StringVar(&authOIDCIssuerURLFlag, "auth.oidc.issuer-url", "", "The issuer url of the OIDC provider.")
+	fs.StringVar(&authOIDCRedirectURLFlag, "auth.oidc.redirect-url", "", "The redirect url for the OIDC provider, e.g. https://kubenav.example.com/api/auth/callback.")
+	fs.StringVar(&authOIDCScopesFlag, "auth.oidc.scopes", "email,groups", "Comma separated list of scopes for the OIDC provider.")
+	fs.StringVar(&authOIDCUsernameClaimFlag, "auth.oidc.username-claim", "email", "The claim of the ID token, which is used as user name.")
+	fs.StringVar(&authTokenFileFlag, "auth.token.file", "", "Path to a CSV file with static tokens (token,user,uid,\"group1,group2\").")
+	fs.BoolVar(&cacheFlag, "cache", false, "Serve list and get requests for common resources from an informer cache.")
+	fs.BoolVar(&corsAllowCredentialsFlag, "cors.allow-credentials", false, "Allow credentials (cookies) for cross-origin requests. This can not be used together with the \"*\" origin.")
+	fs.StringSliceVar(&corsAllowedHeadersFlag, "cors.allowed-headers", middleware.DefaultCorsConfig.AllowedHeaders, "Comma separated list of allowed headers for cross-origin requests.")
+	fs.StringSliceVar(&corsAllowedMethodsFlag, "cors.allowed-methods", middleware.DefaultCorsConfig.AllowedMethods, "Comma separated list of allowed methods for cross-origin requests.")
+	fs.StringSliceVar(&corsAllowedOriginsFlag, "cors.allowed-origins", middleware.DefaultCorsConfig.AllowedOrigins, "Comma separated list of allowed origins for cross-origin requests, e.g. \"https://*.example.com\". Requests from the same origin are always allowed.")
+	fs.StringVar(&corsConfigFlag, "cors.config", "", "Path to a YAML file with the CORS policy. The values from the file overwrite the \"cors.*\" flags.")
+	fs.IntVar(&corsMaxAgeFlag, "cors.max-age", 0, "Number of seconds the results of a preflight request can be cached.")
 	fs.BoolVar(&debugFlag, "debug", false, "Enable debug mode.")
 	fs.StringVar(&debugIonicFlag, "debug.ionic", "build", "Path to the Ionic app.")
+	fs.Int64Var(&filesMaxSizeFlag, "files.max-size", files.GetMaxSize(), "Maximum number of bytes, which can be copied to or from a container in a single request.")
+	fs.BoolVar(&impersonationFlag, "impersonation", false, "Allow requests to impersonate other users and groups.")
 	fs.BoolVar(&inclusterFlag, "incluster", false, "Use the in cluster configuration.")
 	fs.StringVar(&kubeconfigFlag, "kubeconfig", "", "Optional Kubeconfig file.")
+	fs.StringVar(&listenAddressFlag, "listen-address", server.DefaultConfig.Address, "The address where the server is listening.")
 	fs.StringVar(&pluginElasticsearchAddressFlag, "plugin.elasticsearch.address", "", "The address for Elasticsearch.")
 	fs.BoolVar(&pluginElasticsearchEnabledFlag, "plugin.elasticsearch.enabled", false, "Enable the Elasticsearch plugin.")
 	fs.StringVar(&pluginElasticsearchPasswordFlag, "plugin.elasticsearch.password", defaultPluginElasticsearchPasswordFlag, "The password for Elasticsearch.")
@@ -91,7 +173,18 @@ func init() {
 	fs.BoolVar(&pluginPrometheusEnabledFlag, "plugin.prometheus.enabled", false, "Enable the Prometheus plugin.")
 	fs.StringVar(&pluginPrometheusPasswordFlag, "plugin.prometheus.password", defaultPluginPrometheusPasswordFlag, "The password for Prometheus.")
 	fs.StringVar(&pluginPrometheusUsernameFlag, "plugin.prometheus.username", defaultPluginPrometheusUsernameFlag, "The username for Prometheus.")
+	fs.StringVar(&recordingsDirFlag, "recordings-dir", "", "Directory, where exec and SSH sessions are recorded in the asciicast format. If empty, sessions are not recorded.")
+	fs.DurationVar(&serverIdleTimeoutFlag, "server.idle-timeout", server.DefaultConfig.IdleTimeout, "Maximum time to wait for the next request, when keep-alives are enabled.")
+	fs.DurationVar(&serverReadTimeoutFlag, "server.read-timeout", 0, "Maximum duration for reading the entire request. Must be 0 or large enough for terminal sessions.")
+	fs.DurationVar(&serverShutdownTimeoutFlag, "server.shutdown-timeout", server.DefaultConfig.ShutdownTimeout, "Maximum time to wait for active requests, when the server is stopped.")
+	fs.DurationVar(&serverWriteTimeoutFlag, "server.write-timeout", 0, "Maximum duration before timing out writes of the response. Must be 0 or large enough for log streams.")
+	fs.DurationVar(&sessionBindTimeoutFlag, "session.bind-timeout", terminal.DefaultSessionConfig.BindTimeout, "Time after which terminal, log and watch sessions are removed, when they were not used by the client.")
+	fs.DurationVar(&sessionIdleTimeoutFlag, "session.idle-timeout", 0, "Time after which terminal sessions without input or output are closed. If 0, idle sessions are not closed.")
+	fs.DurationVar(&sessionMaxLifetimeFlag, "session.max-lifetime", 0, "Maximum lifetime of terminal and log sessions. If 0, the lifetime is not limited.")
 	fs.BoolVar(&showVersion, "version", false, "Print version information.")
+	fs.StringVar(&tlsCertFileFlag, "tls.cert-file", "", "Path to the TLS certificate. The certificate is reloaded when the file is changed.")
+	fs.StringVar(&tlsClientCAFileFlag, "tls.client-ca-file", "", "Path to a CA file. When set, all clients must present a certificate signed by this CA.")
+	fs.StringVar(&tlsKeyFileFlag, "tls.key-file", "", "Path to the TLS private key.")
 }
 
 func main() {
@@ -120,13 +213,56 @@ func main() {
 	log.WithFields(version.Info()).Infof("Version information")
 	log.WithFields(version.BuildContext()).Infof("Build context")
 
-	kubeClient, err := kube.NewClient(false, inclusterFlag, kubeconfigFlag, "", "")
+	if err := metrics.Register(); err != nil {
+		log.WithError(err).Fatalf("Could not register metrics")
+	}
+
+	kubeClient, err := kube.NewClient(false, inclusterFlag, kubeconfigFlag, "", "", cacheFlag)
 	if err != nil {
 		log.WithError(err).Fatalf("Could not create Kubernetes client")
 	}
 
+	corsConfig, err := middleware.LoadCorsConfig(corsConfigFlag, middleware.CorsConfig{
+		AllowedOrigins:   corsAllowedOriginsFlag,
+		AllowedMethods:   corsAllowedMethodsFlag,
+		AllowedHeaders:   corsAllowedHeadersFlag,
+		AllowCredentials: corsAllowCredentialsFlag,
+		MaxAge:           corsMaxAgeFlag,
+	})
+	if err != nil {
+		log.WithError(err).Fatalf("Could not load CORS policy")
+	}
+	if err := middleware.SetCorsConfig(corsConfig); err != nil {
+		log.WithError(err).Fatalf("Invalid CORS policy")
+	}
+
+	authenticator, err := getAuthenticator()
+	if err != nil {
+		log.WithError(err).Fatalf("Could not create authenticator")
+	}
+	if authenticator == nil {
+		log.Warnf("Authentication is disabled, sessions are only protected by their random session id")
+	}
+
+	terminal.SetSessionConfig(terminal.SessionConfig{
+		BindTimeout: sessionBindTimeoutFlag,
+		IdleTimeout: sessionIdleTimeoutFlag,
+		MaxLifetime: sessionMaxLifetimeFlag,
+	})
+
+	if err := terminal.SetRecordingsDir(recordingsDirFlag); err != nil {
+		log.WithError(err).Fatalf("Could not create recordings directory")
+	}
+
+	files.SetMaxSize(filesMaxSizeFlag)
+
+	auditor, err := getAuditor()
+	if err != nil {
+		log.WithError(err).Fatalf("Could not create audit logger")
+	}
+
 	router := http.NewServeMux()
-	apiClient := api.NewClient(false, &plugins.Config{
+	apiClient := api.NewClient(false, impersonationFlag, authenticator, authAdminGroupsFlag, auditor, &plugins.Config{
 		Prometheus: &prometheus.Config{
 			Enabled:             pluginPrometheusEnabledFlag,
 			Address:             pluginPrometheusAddressFlag,
@@ -148,6 +284,7 @@ func main() {
 		},
 	}, kubeClient)
 	apiClient.Register(router)
+	apiClient.RegisterMetrics(router, metrics.Handler())
 
 	index, err := ioutil.ReadFile(path.Join(debugIonicFlag, "index.html"))
 	if err != nil {
@@ -164,7 +301,92 @@ func main() {
 		fmt.Fprintf(w, string(index))
 	})
 
-	if err := http.ListenAndServe(":14122", router); err != nil {
+	// Start the server and wait for a SIGINT or SIGTERM signal. When we receive one of these signals, the server is
+	// stopped gracefully and all active sessions are closed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx, server.Config{
+		Address:          listenAddressFlag,
+		TLSCertFile:      tlsCertFileFlag,
+		TLSKeyFile:       tlsKeyFileFlag,
+		TLSClientCAFile:  tlsClientCAFileFlag,
+		ReadTimeout:      serverReadTimeoutFlag,
+		WriteTimeout:     serverWriteTimeoutFlag,
+		IdleTimeout:      serverIdleTimeoutFlag,
+		ShutdownTimeout:  serverShutdownTimeoutFlag,
+		CertReloadPeriod: server.DefaultConfig.CertReloadPeriod,
+	}, router); err != nil {
 		log.WithError(err).Fatalf("kubenav server died")
 	}
+
+	kubeClient.Close()
+	auditor.Close()
+}
+
+// getAuditor returns the audit logger for the configured sinks. If no sink and no transcripts directory is configured,
+// nil is returned and the audit log is disabled.
+func getAuditor() (*audit.Logger, error) {
+	if len(auditSinksFlag) == 0 && auditTranscriptsDirFlag == "" {
+		return nil, nil
+	}
+
+	var sinks []audit.Sink
+	for _, sink := range auditSinksFlag {
+		switch sink {
+		case "file":
+			fileSink, err := audit.NewFileSink(auditFileFlag)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+		case "stdout":
+			sinks = append(sinks, audit.NewStdoutSink())
+		case "webhook":
+			webhookSink, err := audit.NewWebhookSink(auditWebhookURLFlag)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, webhookSink)
+		default:
+			return nil, fmt.Errorf("unknown audit sink %s", sink)
+		}
+	}
+
+	return audit.NewLogger(sinks, auditTranscriptsDirFlag)
+}
+
+// getAuthenticator returns the authenticator for the configured authentication method. If no authentication method is
+// configured, nil is returned and all API routes can be used without authentication.
+func getAuthenticator() (middleware.Authenticator, error) {
+	switch authFlag {
+	case "":
+		return nil, nil
+	case "token":
+		return middleware.NewTokenAuthenticator(authTokenFileFlag)
+	case "header":
+		return middleware.NewHeaderAuthenticator(authHeaderUserFlag, authHeaderGroupsFlag, authHeaderTrustedProxiesFlag)
+	case "oidc":
+		var certificateAuthority []byte
+		if authOIDCCertificateAuthorityFlag != "" {
+			var err error
+			certificateAuthority, err = ioutil.ReadFile(authOIDCCertificateAuthorityFlag)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return api.NewOIDCAuthenticator(api.OIDCConfig{
+			IssuerURL:            authOIDCIssuerURLFlag,
+			ClientID:             authOIDCClientIDFlag,
+			ClientSecret:         authOIDCClientSecretFlag,
+			RedirectURL:          authOIDCRedirectURLFlag,
+			Scopes:               authOIDCScopesFlag,
+			CertificateAuthority: string(certificateAuthority),
+			UsernameClaim:        authOIDCUsernameClaimFlag,
+			GroupsClaim:          authOIDCGroupsClaimFlag,
+		})
+	}
+
+	return nil, fmt.Errorf("unknown authentication method %s", authFlag)
 }
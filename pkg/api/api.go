@@ -4,14 +4,20 @@ import (
 	"net/http"
 
 	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins"
 	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/handlers/watch"
 	"github.com/kubenav/kubenav/pkg/kube"
 )
 
 // Client implements the structure of our API client.
 type Client struct {
 	syncKubeconfig bool
+	impersonation  bool
+	authenticator  middleware.Authenticator
+	adminGroups    []string
+	auditor        *audit.Logger
 	pluginConfig   *plugins.Config
 	kubeClient     kube.Client
 }
@@ -23,54 +29,109 @@ func (c *Client) Register(router *http.ServeMux) {
 	// For the server implementation of kubenav this API endpoint can be used for the liveness and readiness probe.
 	router.HandleFunc("/api/health", middleware.Cors(c.healthHandler))
 
+	// The auth handlers are used for the authentication of users, when kubenav is running as server and an
+	// authenticator is configured. The login and callback handlers are only used for the OIDC authentication. All
+	// other API routes are protected by the configured authenticator.
+	router.HandleFunc("/api/auth/login", middleware.Cors(c.authLoginHandler))
+	router.HandleFunc("/api/auth/callback", middleware.Cors(c.authCallbackHandler))
+	router.HandleFunc("/api/auth/logout", middleware.Cors(c.authLogoutHandler))
+	router.HandleFunc("/api/auth/user", middleware.Cors(c.auth(c.authUserHandler)))
+
 	// The AWS handlers are used to handle the authentication against AWS for the mobile implementation of kubenav.
-	router.HandleFunc("/api/aws/clusters", middleware.Cors(c.awsGetClustersHandler))
-	router.HandleFunc("/api/aws/token", middleware.Cors(c.awsGetTokenHandler))
-	router.HandleFunc("/api/aws/ssoconfig", middleware.Cors(c.awsGetSSOConfigHandler))
-	router.HandleFunc("/api/aws/ssotoken", middleware.Cors(c.awsGetSSOTokenHandler))
+	router.HandleFunc("/api/aws/clusters", middleware.Cors(c.auth(c.awsGetClustersHandler)))
+	router.HandleFunc("/api/aws/token", middleware.Cors(c.auth(c.awsGetTokenHandler)))
+	router.HandleFunc("/api/aws/ssoconfig", middleware.Cors(c.auth(c.awsGetSSOConfigHandler)))
+	router.HandleFunc("/api/aws/ssotoken", middleware.Cors(c.auth(c.awsGetSSOTokenHandler)))
 
-	router.HandleFunc("/api/rancher/listclusters", middleware.Cors(c.rancherListClustersHandler))
-	router.HandleFunc("/api/rancher/kubeconfig", middleware.Cors(c.rancherKubeconfigHandler))
-	router.HandleFunc("/api/rancher/generateapitoken", middleware.Cors(c.rancherGenerateApiTokenHandler))
+	router.HandleFunc("/api/rancher/listclusters", middleware.Cors(c.auth(c.rancherListClustersHandler)))
+	router.HandleFunc("/api/rancher/kubeconfig", middleware.Cors(c.auth(c.rancherKubeconfigHandler)))
+	router.HandleFunc("/api/rancher/generateapitoken", middleware.Cors(c.auth(c.rancherGenerateApiTokenHandler)))
 
 	// The Azure handler is used to retrieve all AKS clusters from Azure for the mobile implementation of kubenav.
-	router.HandleFunc("/api/azure/clusters", middleware.Cors(c.azureGetClustersHandler))
+	router.HandleFunc("/api/azure/clusters", middleware.Cors(c.auth(c.azureGetClustersHandler)))
 
 	// The clusters handler returns the current cluster and all clusters from a loaded Kubeconfig file for the server
 	// and desktop implementation of kubenav.
-	router.HandleFunc("/api/cluster", middleware.Cors(c.clusterHandler))
-	router.HandleFunc("/api/clusters", middleware.Cors(c.clustersHandler))
+	router.HandleFunc("/api/cluster", middleware.Cors(c.auth(c.clusterHandler)))
+	router.HandleFunc("/api/clusters", middleware.Cors(c.auth(c.clustersHandler)))
+
+	// The cache handler returns the sync state of the informer cache, which can be enabled via the "cache" flag for the
+	// server and desktop implementation of kubenav.
+	router.HandleFunc("/api/cache", middleware.Cors(c.auth(c.cacheHandler)))
 
 	// The Kubernetes handlers are used for requests against the Kubernetes API. In addition to the normal requests we
-	// are also handling exec requests into a pod, the streaming of log files, SSH connections to nodes, port forwarding
-	// and the plugin logic, which is also implemented via port forwarding.
-	router.HandleFunc("/api/kubernetes/request", middleware.Cors(c.kubernetesRequestHandler))
-	router.HandleFunc("/api/kubernetes/exec", middleware.Cors(c.kubernetesExecHandler))
-	router.Handle("/api/kubernetes/exec/sockjs/", terminal.CreateAttachHandler("/api/kubernetes/exec/sockjs"))
-	router.HandleFunc("/api/kubernetes/logs", middleware.Cors(c.kubernetesLogsHandler))
-	router.HandleFunc("/api/kubernetes/logs/", middleware.Cors(terminal.StreamLogsHandler))
-	router.HandleFunc("/api/kubernetes/ssh", middleware.Cors(c.kubernetesSSHHandler))
-	router.Handle("/api/kubernetes/ssh/sockjs/", terminal.CreateSSHHandler("/api/kubernetes/ssh/sockjs"))
-	router.HandleFunc("/api/kubernetes/portforwarding", middleware.Cors(c.kubernetesPortForwardingHandler))
-	router.HandleFunc("/api/kubernetes/plugins", middleware.Cors(c.kubernetesPluginHandler))
+	// are also handling exec requests into a pod (interactive or with captured output), the copy of files to and from a
+	// container, ephemeral debug containers, the streaming and download of log files, SSH connections to nodes, node
+	// shells via privileged Pods, port forwarding and the plugin logic, which is also implemented via port forwarding.
+	// The watch handlers are used to stream changes of Kubernetes resources to the frontend, so that the frontend
+	// hasn't to poll the Kubernetes API. The recordings handlers are used to list, download and replay the recordings
+	// of exec and SSH sessions. All terminal sessions can be used via SockJS or via a plain WebSocket connection ("ws"
+	// routes).
+	router.HandleFunc("/api/kubernetes/request", middleware.Cors(c.auth(c.kubernetesRequestHandler)))
+	router.HandleFunc("/api/kubernetes/exec", middleware.Cors(c.auth(c.kubernetesExecHandler)))
+	router.Handle("/api/kubernetes/exec/sockjs/", middleware.CheckOrigin(c.auth(terminal.CreateAttachHandler("/api/kubernetes/exec/sockjs", c.terminalClient).ServeHTTP)))
+	router.Handle("/api/kubernetes/exec/ws", middleware.CheckOrigin(c.auth(terminal.CreateWebSocketHandler(c.terminalClient, middleware.AllowedOrigin).ServeHTTP)))
+	router.HandleFunc("/api/kubernetes/exec/run", middleware.Cors(c.auth(c.kubernetesExecRunHandler)))
+	router.HandleFunc("/api/kubernetes/files/download", middleware.Cors(c.auth(c.kubernetesFilesDownloadHandler)))
+	router.HandleFunc("/api/kubernetes/files/upload", middleware.Cors(c.auth(c.kubernetesFilesUploadHandler)))
+	router.HandleFunc("/api/kubernetes/debug", middleware.Cors(c.auth(c.kubernetesDebugHandler)))
+	router.HandleFunc("/api/kubernetes/node/shell", middleware.Cors(c.auth(c.kubernetesNodeShellHandler)))
+	router.HandleFunc("/api/kubernetes/logs", middleware.Cors(c.auth(c.kubernetesLogsHandler)))
+	router.HandleFunc("/api/kubernetes/logs/download", middleware.Cors(c.auth(c.kubernetesLogsDownloadHandler)))
+	router.HandleFunc("/api/kubernetes/logs/", middleware.Cors(c.auth(terminal.CreateStreamLogsHandler(c.terminalClient))))
+	router.HandleFunc("/api/kubernetes/watch", middleware.Cors(c.auth(c.kubernetesWatchHandler)))
+	router.HandleFunc("/api/kubernetes/watch/", middleware.Cors(c.auth(watch.StreamWatchHandler)))
+	router.HandleFunc("/api/kubernetes/ssh", middleware.Cors(c.auth(c.kubernetesSSHHandler)))
+	router.Handle("/api/kubernetes/ssh/sockjs/", middleware.CheckOrigin(c.auth(terminal.CreateSSHHandler("/api/kubernetes/ssh/sockjs", c.terminalClient).ServeHTTP)))
+	router.Handle("/api/kubernetes/ssh/ws", middleware.CheckOrigin(c.auth(terminal.CreateWebSocketHandler(c.terminalClient, middleware.AllowedOrigin).ServeHTTP)))
+	router.HandleFunc("/api/kubernetes/recordings", middleware.Cors(c.auth(c.kubernetesRecordingsHandler)))
+	router.HandleFunc("/api/kubernetes/recordings/", middleware.Cors(c.auth(c.kubernetesRecordingHandler)))
+	router.Handle("/api/kubernetes/recordings/sockjs/", middleware.CheckOrigin(c.auth(terminal.CreateReplayHandler("/api/kubernetes/recordings/sockjs", c.terminalClient).ServeHTTP)))
+	router.Handle("/api/kubernetes/recordings/ws", middleware.CheckOrigin(c.auth(terminal.CreateWebSocketReplayHandler(c.terminalClient, middleware.AllowedOrigin).ServeHTTP)))
+	router.HandleFunc("/api/kubernetes/portforwarding", middleware.Cors(c.auth(c.kubernetesPortForwardingHandler)))
+	router.HandleFunc("/api/kubernetes/plugins", middleware.Cors(c.auth(c.kubernetesPluginHandler)))
+
+	// The sessions handler is used to list and terminate all active terminal, log, watch and port forwarding sessions.
+	router.HandleFunc("/api/sessions", middleware.Cors(c.auth(c.sessionsHandler)))
 
 	// The OIDC handlers are used for the authentication against a Kubernetes cluster using OIDC. This is only used by
 	// the mobile implementation of kubenav.
-	router.HandleFunc("/api/oidc/link", middleware.Cors(c.oidcGetLinkHandler))
-	router.HandleFunc("/api/oidc/refreshtoken", middleware.Cors(c.oidcGetRefreshTokenHandler))
-	router.HandleFunc("/api/oidc/accesstoken", middleware.Cors(c.oidcGetAccessTokenHandler))
+	router.HandleFunc("/api/oidc/link", middleware.Cors(c.auth(c.oidcGetLinkHandler)))
+	router.HandleFunc("/api/oidc/refreshtoken", middleware.Cors(c.auth(c.oidcGetRefreshTokenHandler)))
+	router.HandleFunc("/api/oidc/accesstoken", middleware.Cors(c.auth(c.oidcGetAccessTokenHandler)))
 
 	// The sync handlers are used to write changes against the active cluster/context and the selected namespace back to
 	// the loaded Kubeconfig file. This is only used by the desktop implementation of kubenav and must be enabled via
 	// the "kubeconfig.sync" flag.
-	router.HandleFunc("/api/sync/context", middleware.Cors(c.syncContextHandler))
-	router.HandleFunc("/api/sync/namespace", middleware.Cors(c.syncNamespaceHandler))
+	router.HandleFunc("/api/sync/context", middleware.Cors(c.auth(c.syncContextHandler)))
+	router.HandleFunc("/api/sync/namespace", middleware.Cors(c.auth(c.syncNamespaceHandler)))
+}
+
+// RegisterMetrics adds the route for the Prometheus metrics of kubenav, e.g. the hit rate of the Kubernetes API client
+// pool. The metrics are protected by the configured authenticator, because they contain information about the
+// clusters. The handler is passed by the caller, so that Prometheus isn't part of the mobile build.
+func (c *Client) RegisterMetrics(router *http.ServeMux, handler http.Handler) {
+	router.HandleFunc("/metrics", c.auth(handler.ServeHTTP))
+}
+
+// auth protects the given handler with the configured authenticator. When no authenticator is configured, all requests
+// are passed to the handler.
+func (c *Client) auth(next http.HandlerFunc) http.HandlerFunc {
+	return middleware.Auth(c.authenticator, next)
 }
 
 // NewClient returns an new API client which then can be used to register all API routes to an existing router.
-func NewClient(syncKubeconfig bool, pluginConfig *plugins.Config, kubeClient kube.Client) *Client {
+// When impersonation is false, all requests which contain impersonation settings are rejected. When an authenticator
+// is provided, all API routes are protected and the authenticated user is passed to the Kubernetes API via
+// impersonation. Members of the admin groups can see and terminate the sessions of all users. When an audit logger is
+// provided, all mutating requests and interactive sessions are recorded.
+func NewClient(syncKubeconfig, impersonation bool, authenticator middleware.Authenticator, adminGroups []string, auditor *audit.Logger, pluginConfig *plugins.Config, kubeClient kube.Client) *Client {
 	return &Client{
 		syncKubeconfig: syncKubeconfig,
+		impersonation:  impersonation,
+		authenticator:  authenticator,
+		adminGroups:    adminGroups,
+		auditor:        auditor,
 		pluginConfig:   pluginConfig,
 		kubeClient:     kubeClient,
 	}
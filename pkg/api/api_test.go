@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+)
+
+// testAuthenticator authenticates all requests with the "Authorization: Bearer valid" header.
+type testAuthenticator struct{}
+
+func (a testAuthenticator) Authenticate(r *http.Request) (*middleware.User, error) {
+	if r.Header.Get("Authorization") != "Bearer valid" {
+		return nil, fmt.Errorf("token is invalid")
+	}
+
+	return &middleware.User{Name: "alice"}, nil
+}
+
+func TestRegisterAuth(t *testing.T) {
+	router := http.NewServeMux()
+	client := NewClient(false, false, testAuthenticator{}, nil, nil, nil, nil)
+	client.Register(router)
+	client.RegisterMetrics(router, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name           string
+		method         string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{name: "metrics without credentials", method: http.MethodGet, path: "/metrics", expectedStatus: http.StatusUnauthorized},
+		{name: "metrics options without credentials", method: http.MethodOptions, path: "/metrics", expectedStatus: http.StatusUnauthorized},
+		{name: "metrics options with origin without credentials", method: http.MethodOptions, path: "/metrics", headers: map[string]string{"Origin": "https://example.com"}, expectedStatus: http.StatusUnauthorized},
+		{name: "metrics preflight", method: http.MethodOptions, path: "/metrics", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET"}, expectedStatus: http.StatusNoContent},
+		{name: "metrics with credentials", method: http.MethodGet, path: "/metrics", headers: map[string]string{"Authorization": "Bearer valid"}, expectedStatus: http.StatusOK},
+		{name: "sessions options without credentials", method: http.MethodOptions, path: "/api/sessions", expectedStatus: http.StatusUnauthorized},
+		{name: "sessions preflight", method: http.MethodOptions, path: "/api/sessions", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "DELETE"}, expectedStatus: http.StatusNoContent},
+		{name: "health without credentials", method: http.MethodGet, path: "/api/health", expectedStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statusRecorder wraps a http.ResponseWriter to record the status code of the response for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader records the status code and writes it to the wrapped http.ResponseWriter.
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.code = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// isMutatingMethod returns true for all methods, which are changing a resource in the Kubernetes API.
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// auditEvent returns a new audit event with the identity of the user. When the request was authenticated, the
+// authenticated user is used, otherwise we use the impersonated user from the request body.
+func auditEvent(r *http.Request, eventType, cluster, impersonateUser string, impersonateGroups []string) audit.Event {
+	event := audit.Event{
+		Time:       time.Now(),
+		Type:       eventType,
+		User:       impersonateUser,
+		Groups:     impersonateGroups,
+		RemoteAddr: r.RemoteAddr,
+		Cluster:    cluster,
+	}
+
+	if user, ok := middleware.UserFromContext(r.Context()); ok {
+		event.User = user.Name
+		event.Groups = user.Groups
+	}
+
+	return event
+}
+
+// auditSession creates a transcript for the given terminal session, when transcripts are enabled, and writes the
+// "session.start" event. The returned function must be called with the error of the session, when the session is
+// closed, to write the "session.end" event.
+func (c *Client) auditSession(event audit.Event, session *terminal.TerminalSession) func(err error) {
+	var transcript io.WriteCloser
+
+	if c.auditor != nil {
+		var err error
+		transcript, event.Transcript, err = c.auditor.Transcript(session.ID)
+		if err != nil {
+			log.WithError(err).Errorf("Could not create terminal transcript")
+		} else if transcript != nil {
+			session.Transcript = transcript
+		}
+	}
+
+	start := time.Now()
+	event.Time = start
+	event.Type = audit.EventTypeSessionStart
+	event.SessionID = session.ID
+	c.auditor.Log(event)
+
+	return func(err error) {
+		if transcript != nil {
+			transcript.Close()
+		}
+
+		event.Time = time.Now()
+		event.Type = audit.EventTypeSessionEnd
+		event.DurationSeconds = time.Since(start).Seconds()
+		if err != nil {
+			event.Error = err.Error()
+		}
+
+		c.auditor.Log(event)
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+
+	"github.com/coreos/go-oidc"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcTokenCookieName is the name of the cookie, which contains the ID token of the authenticated user.
+	oidcTokenCookieName = "kubenav_id_token"
+	// oidcStateCookieName is the name of the cookie, which contains the state of an OIDC login flow.
+	oidcStateCookieName = "kubenav_oidc_state"
+)
+
+// OIDCConfig is the structure of the configuration for the OIDC authentication of the kubenav server. The certificate
+// authority must contain the PEM encoded certificate of the OIDC provider, when the provider uses a self signed
+// certificate.
+type OIDCConfig struct {
+	IssuerURL            string
+	ClientID             string
+	ClientSecret         string
+	RedirectURL          string
+	Scopes               string
+	CertificateAuthority string
+	UsernameClaim        string
+	GroupsClaim          string
+}
+
+// OIDCAuthenticator authenticates requests via the ID token of an OIDC provider. The ID token is retrieved via the
+// authorization code flow, which is handled by the authLoginHandler and authCallbackHandler, and saved in a cookie.
+type OIDCAuthenticator struct {
+	config       OIDCConfig
+	ctx          context.Context
+	oauth2Config oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+// NewOIDCAuthenticator returns a new authenticator for the configured OIDC provider.
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	ctx, err := oidcContext(context.Background(), config.CertificateAuthority)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "email"
+	}
+
+	return &OIDCAuthenticator{
+		config: config,
+		ctx:    ctx,
+		oauth2Config: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       oidcScopes(config.Scopes),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// Authenticate verifies the ID token from the Authorization header or the kubenav_id_token cookie and returns the
+// user from the configured username and groups claims.
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*middleware.User, error) {
+	rawIDToken := middleware.BearerToken(r, oidcTokenCookieName)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("id token is missing")
+	}
+
+	idToken, err := a.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	name, ok := claims[a.config.UsernameClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("id token doesn't contain the %s claim", a.config.UsernameClaim)
+	}
+
+	user := &middleware.User{Name: name}
+	if a.config.GroupsClaim != "" {
+		switch groups := claims[a.config.GroupsClaim].(type) {
+		case string:
+			user.Groups = []string{groups}
+		case []interface{}:
+			for _, group := range groups {
+				if g, ok := group.(string); ok {
+					user.Groups = append(user.Groups, g)
+				}
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// authLoginHandler starts the authorization code flow for the configured OIDC provider. The generated state is saved
+// in a cookie, so that it can be validated in the authCallbackHandler.
+func (c *Client) authLoginHandler(w http.ResponseWriter, r *http.Request) {
+	authenticator, ok := c.authenticator.(*OIDCAuthenticator)
+	if !ok {
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "OIDC authentication is not enabled")
+		return
+	}
+
+	state, err := createVerifier()
+	if err != nil {
+		log.WithError(err).Errorf("Could not create OIDC state")
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create OIDC state: %s", err.Error()))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/",
+		Expires:  time.Now().Add(10 * time.Minute),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authenticator.oauth2Config.AuthCodeURL(state), http.StatusFound)
+}
+
+// authCallbackHandler handles the redirect from the OIDC provider. The returned code is exchanged for an ID token,
+// which is saved in a cookie and used to authenticate all following requests.
+func (c *Client) authCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	authenticator, ok := c.authenticator.(*OIDCAuthenticator)
+	if !ok {
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "OIDC authentication is not enabled")
+		return
+	}
+
+	state, err := r.Cookie(oidcStateCookieName)
+	if err != nil || state.Value == "" || state.Value != r.URL.Query().Get("state") {
+		log.Errorf("Invalid OIDC state")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Invalid OIDC state")
+		return
+	}
+
+	oauth2Token, err := authenticator.oauth2Config.Exchange(authenticator.ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		log.WithError(err).Errorf("Could not get oauth token")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not get oauth token: %s", err.Error()))
+		return
+	}
+
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		log.Errorf("Could not get id token")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Could not get id token")
+		return
+	}
+
+	idToken, err := authenticator.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.WithError(err).Errorf("Could not verify id token")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not verify id token: %s", err.Error()))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcTokenCookieName,
+		Value:    rawIDToken,
+		Path:     "/",
+		Expires:  idToken.Expiry,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// authUserHandler returns the authenticated user. When authentication is disabled, an empty response is returned.
+func (c *Client) authUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	middleware.Write(w, r, user)
+}
+
+// authLogoutHandler removes the cookies, which are used to authenticate the user.
+func (c *Client) authLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	for _, name := range []string{oidcTokenCookieName, middleware.TokenCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
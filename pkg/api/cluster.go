@@ -55,3 +55,28 @@ func (c *Client) clustersHandler(w http.ResponseWriter, r *http.Request) {
 	middleware.Write(w, r, data)
 	return
 }
+
+// cacheHandler returns the sync state of the informer cache for all clusters. The used kubeClient.CacheStatus()
+// function only works for the server and desktop implementation of kubenav, when the cache is enabled via the "cache"
+// flag. When this function is called on mobile or the cache is disabled an error is returned.
+func (c *Client) cacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	status, err := c.kubeClient.CacheStatus()
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not load cache status: %s", err.Error()))
+		return
+	}
+
+	data := struct {
+		Clusters map[string]types.CacheStatus `json:"clusters"`
+	}{
+		status,
+	}
+
+	middleware.Write(w, r, data)
+	return
+}
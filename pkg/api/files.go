@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
+	"github.com/kubenav/kubenav/pkg/handlers/files"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// kubernetesFilesDownloadHandler handles the requests to download a file or directory from a container. A file is
+// returned as it is, a directory is returned as tar archive.
+func (c *Client) kubernetesFilesDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request files.Request
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	w = recorder
+	defer c.auditFiles(r, clientset, request, false, recorder)
+
+	download, err := files.NewDownload(r.Context(), config, clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not download files")
+		code := http.StatusBadRequest
+		if err == files.ErrSizeLimit {
+			code = http.StatusRequestEntityTooLarge
+		}
+		middleware.Errorf(w, r, err, code, fmt.Sprintf("Could not download files: %s", err.Error()))
+		return
+	}
+
+	if download.File {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", download.Size))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download.Name))
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download.Name+".tar"))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// The status code was already sent, so that we can only log errors and abort the response.
+	if _, err := download.WriteTo(w); err != nil {
+		log.WithError(err).Errorf("Could not write files")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// kubernetesFilesUploadHandler handles the requests to upload files to a directory in a container. The request must be
+// a multipart request, where the first part with the name "request" contains the request as JSON. All following
+// "file" and "archive" parts are copied to the container (see files.Upload).
+func (c *Client) kubernetesFilesUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		log.WithError(err).Errorf("Could not read multipart request")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not read multipart request: %s", err.Error()))
+		return
+	}
+
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != "request" {
+		log.WithError(err).Errorf("First part must be the request")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, "First part must be the request")
+		return
+	}
+
+	var request files.Request
+	err = json.NewDecoder(part).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	w = recorder
+	defer c.auditFiles(r, clientset, request, true, recorder)
+
+	response, err := files.Upload(r.Context(), config, clientset, request, reader)
+	if err != nil {
+		log.WithError(err).Errorf("Could not upload files")
+		code := http.StatusBadRequest
+		if err == files.ErrSizeLimit {
+			code = http.StatusRequestEntityTooLarge
+		}
+		middleware.Errorf(w, r, err, code, fmt.Sprintf("Could not upload files: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, response)
+}
+
+// auditFiles writes the download or upload of files to the audit log. The url of the event is the url of the exec
+// request, which contains the executed tar command.
+func (c *Client) auditFiles(r *http.Request, clientset *kubernetes.Clientset, request files.Request, upload bool, recorder *statusRecorder) {
+	event := auditEvent(r, audit.EventTypeRequest, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+	event.Method = http.MethodPost
+	event.Code = recorder.code
+
+	if command, err := files.Command(request, upload); err == nil {
+		event.URL = terminal.ExecURL(clientset, request.Namespace, request.Name, request.Container, command, upload).RequestURI()
+	}
+
+	c.auditor.Log(event)
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -9,15 +11,45 @@ import (
 	"time"
 
 	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
+	"github.com/kubenav/kubenav/pkg/handlers/debug"
 	"github.com/kubenav/kubenav/pkg/handlers/plugins"
 	"github.com/kubenav/kubenav/pkg/handlers/portforwarding"
 	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/handlers/watch"
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/kube/types"
 
 	log "github.com/sirupsen/logrus"
-	"k8s.io/client-go/tools/remotecommand"
 )
 
+// credentials returns the credentials for the Kubernetes API client from the request. When the request contains
+// impersonation settings, but impersonation wasn't enabled via the "impersonation" flag, an error is returned.
+// When the request was authenticated, the authenticated user is always used for impersonation, so that the RBAC rules
+// of the cluster are applied for the user. In this case the request can not contain its own impersonation settings.
+// The returned credentials are always validated (see ValidateImpersonation), so that they can be used for the cache
+// lookup and the Kubernetes API client.
+func (c *Client) credentials(r *http.Request, request kube.Request) (types.ClusterCredentials, error) {
+	credentials := request.Credentials()
+
+	if user, ok := middleware.UserFromContext(r.Context()); ok {
+		if request.IsImpersonated() {
+			return types.ClusterCredentials{}, fmt.Errorf("impersonation is not allowed for authenticated users")
+		}
+
+		credentials.ImpersonateUser = user.Name
+		credentials.ImpersonateGroups = user.Groups
+	} else if request.IsImpersonated() && !c.impersonation {
+		return types.ClusterCredentials{}, fmt.Errorf("impersonation is disabled")
+	}
+
+	if err := credentials.ValidateImpersonation(); err != nil {
+		return types.ClusterCredentials{}, err
+	}
+
+	return credentials, nil
+}
+
 // kubernetesRequestHandler handles the requests against the Kubernetes API server.
 func (c *Client) kubernetesRequestHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -38,14 +70,51 @@ func (c *Client) kubernetesRequestHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	_, clientset, err := c.kubeClient.GetConfigAndClientset(request.Cluster, request.URL, request.CertificateAuthorityData, request.ClientCertificateData, request.ClientKeyData, request.Token, request.Username, request.Password, request.InsecureSkipTLSVerify, time.Duration(request.Timeout)*time.Second, request.Proxy)
+	// All mutating requests are written to the audit log, together with the status code of the response. Read requests
+	// are not recorded, because they would flood the audit log.
+	if isMutatingMethod(request.Method) {
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		w = recorder
+
+		defer func() {
+			event := auditEvent(r, audit.EventTypeRequest, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+			event.Method = request.Method
+			event.URL = request.URL
+			event.Code = recorder.code
+			c.auditor.Log(event)
+		}()
+	}
+
+	credentials, err := c.credentials(r, request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	// When the cache is enabled for the server and desktop implementation, we try to answer GET requests from the cache
+	// first. If the request can not be answered from the cache, we are sending the request to the Kubernetes API server.
+	// Impersonated requests are never answered from the cache, because the cache uses the credentials of kubenav.
+	if request.Method == http.MethodGet && !credentials.IsImpersonated() {
+		result, ok, err := c.kubeClient.CachedRequest(request.Cluster, request.URL)
+		if err != nil {
+			log.WithError(err).Debugf("Could not get result from cache")
+		} else if ok {
+			middleware.Write(w, r, kube.Response{
+				Data: strings.TrimSuffix(string(result), "\n"),
+			})
+			return
+		}
+	}
+
+	_, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, time.Duration(request.Timeout)*time.Second)
 	if err != nil {
 		log.WithError(err).Errorf("Could not create Kubernetes API client")
 		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
 		return
 	}
 
-	result, err := kube.KubernetesRequest(r.Context(), request.Method, request.URL, request.Body, clientset)
+	result, err := kube.KubernetesRequest(r.Context(), request, clientset)
 	if err != nil {
 		var apiError kube.Error
 		if err := json.Unmarshal(result, &apiError); err != nil {
@@ -67,7 +136,8 @@ func (c *Client) kubernetesRequestHandler(w http.ResponseWriter, r *http.Request
 	return
 }
 
-// kubernetesExecHandler handles the requests to get a shell into a container.
+// kubernetesExecHandler handles the requests to get a shell into a container. When the request url is a "pods/attach"
+// url, the terminal session is attached to the main process of the container.
 func (c *Client) kubernetesExecHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		middleware.Write(w, r, nil)
@@ -87,13 +157,47 @@ func (c *Client) kubernetesExecHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	config, clientset, err := c.kubeClient.GetConfigAndClientset(request.Cluster, request.URL, request.CertificateAuthorityData, request.ClientCertificateData, request.ClientKeyData, request.Token, request.Username, request.Password, request.InsecureSkipTLSVerify, 6*time.Hour, request.Proxy)
+	credentials, err := c.credentials(r, request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
 	if err != nil {
 		log.WithError(err).Errorf("Could not create Kubernetes API client")
 		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
 		return
 	}
 
+	reqURL, err := url.Parse(request.URL)
+	if err != nil {
+		log.WithError(err).Errorf("Could not parse request url")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not parse request url: %s", err.Error()))
+		return
+	}
+
+	// When the request url is a "pods/attach" url, the terminal session is attached to the main process of the
+	// container instead of running a new shell. The stdin and tty options are derived from the container spec.
+	sessionType := "exec"
+	info := terminal.NewSessionInfo(sessionType, request.Cluster, request.URL)
+
+	var attachOptions terminal.AttachOptions
+	if strings.HasSuffix(reqURL.Path, "/attach") {
+		sessionType = "attach"
+		info.Type = sessionType
+
+		info.Container, attachOptions, err = terminal.NewAttachOptions(r.Context(), clientset, info.Namespace, info.Pod, info.Container)
+		if err != nil {
+			log.WithError(err).Errorf("Could not get attach options")
+			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not get attach options: %s", err.Error()))
+			return
+		}
+
+		reqURL.RawQuery = attachOptions.Query(info.Container).Encode()
+	}
+
 	sessionID, err := terminal.GenTerminalSessionID()
 	if err != nil {
 		log.WithError(err).Errorf("Could not generate terminal session id")
@@ -101,36 +205,316 @@ func (c *Client) kubernetesExecHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	terminal.TerminalSessions.Set(sessionID, terminal.TerminalSession{
-		ID:       sessionID,
-		Bound:    make(chan error),
-		SizeChan: make(chan remotecommand.TerminalSize),
-	})
+	session := terminal.NewTerminalSession(sessionID, middleware.Identity(r), info)
 
-	shell := ""
-	reqURL, err := url.Parse(request.URL)
-	if err == nil {
-		commands, ok := reqURL.Query()["command"]
-		if ok || len(commands[0]) >= 1 {
+	session.Recorder, err = terminal.NewRecorder(sessionID, session.Owner, fmt.Sprintf("%s: %s", request.Cluster, request.URL))
+	if err != nil {
+		log.WithError(err).Errorf("Could not create recording")
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create recording: %s", err.Error()))
+		return
+	}
+
+	event := auditEvent(r, audit.EventTypeSessionStart, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+	event.SessionType = sessionType
+	event.Method = request.Method
+	event.URL = request.URL
+	auditSessionEnd := c.auditSession(event, &session)
+
+	terminal.TerminalSessions.Set(sessionID, session)
+
+	if sessionType == "attach" {
+		go func() {
+			auditSessionEnd(terminal.WaitForAttach(config, reqURL, attachOptions, sessionID))
+		}()
+	} else {
+		shell := ""
+		if commands := reqURL.Query()["command"]; len(commands) > 0 {
 			shell = commands[0]
 		}
+
+		go func() {
+			auditSessionEnd(terminal.WaitForTerminal(config, clientset, reqURL, shell, sessionID))
+		}()
+		time.Sleep(1 * time.Second)
+	}
+
+	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
+	return
+}
+
+// kubernetesExecRunHandler handles the requests to run a command in a container without a terminal session. The
+// captured output and the exit code of the command are returned. All commands are written to the audit log.
+func (c *Client) kubernetesExecRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request terminal.RunRequest
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	w = recorder
+
+	defer func() {
+		event := auditEvent(r, audit.EventTypeRequest, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+		event.Method = http.MethodPost
+		event.URL = terminal.RunURL(clientset, request).RequestURI()
+		event.Code = recorder.code
+		c.auditor.Log(event)
+	}()
+
+	response, err := terminal.Run(r.Context(), config, clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not run command")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not run command: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, response)
+	return
+}
+
+// kubernetesDebugHandler handles the requests to create an ephemeral debug container in a pod. When the container is
+// running, a new terminal session is created, which is attached to the container.
+func (c *Client) kubernetesDebugHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request debug.Request
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	// Create the ephemeral container and wait until it is running. When the request doesn't contain a timeout, we wait
+	// up to two minutes, so that there is enough time to pull the image for the debug container.
+	timeout := 2 * time.Minute
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	container, err := debug.CreateContainer(ctx, clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create debug container")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create debug container: %s", err.Error()))
+		return
+	}
+
+	reqURL, err := debug.AttachURL(config, request.Namespace, request.Name, container)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create attach url")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create attach url: %s", err.Error()))
+		return
 	}
 
-	go terminal.WaitForTerminal(config, clientset, reqURL, shell, sessionID)
-	time.Sleep(1 * time.Second)
+	sessionID, err := terminal.GenTerminalSessionID()
+	if err != nil {
+		log.WithError(err).Errorf("Could not generate terminal session id")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not generate terminal session id: %s", err.Error()))
+		return
+	}
+
+	session := terminal.NewTerminalSession(sessionID, middleware.Identity(r), terminal.SessionInfo{
+		Type:      "debug",
+		Cluster:   request.Cluster,
+		Namespace: request.Namespace,
+		Pod:       request.Name,
+		Container: container,
+	})
+
+	session.Recorder, err = terminal.NewRecorder(sessionID, session.Owner, fmt.Sprintf("%s: %s", request.Cluster, reqURL.Path))
+	if err != nil {
+		log.WithError(err).Errorf("Could not create recording")
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create recording: %s", err.Error()))
+		return
+	}
+
+	event := auditEvent(r, audit.EventTypeSessionStart, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+	event.SessionType = "debug"
+	event.Method = http.MethodPost
+	event.URL = reqURL.Path
+	auditSessionEnd := c.auditSession(event, &session)
+
+	terminal.TerminalSessions.Set(sessionID, session)
+
+	go func() {
+		auditSessionEnd(terminal.WaitForAttach(config, reqURL, debug.AttachOptions, sessionID))
+	}()
 
 	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
 	return
 }
 
-// kubernetesLogsHandler generates the clientset and an id for streaming logs.
+// kubernetesNodeShellHandler handles the requests to open a shell on a node, without using SSH. Therefore a privileged
+// Pod is created on the node and a new terminal session is attached to the Pod. The Pod is deleted, when the terminal
+// session is closed.
+func (c *Client) kubernetesNodeShellHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request debug.NodeRequest
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	timeout := 2 * time.Minute
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	namespace, name, err := debug.CreateNodePod(ctx, clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create node shell pod")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create node shell pod: %s", err.Error()))
+		return
+	}
+
+	// deletePod deletes the Pod for the node shell. It must be called for all errors after the Pod was created and when
+	// the terminal session is closed.
+	deletePod := func() {
+		if err := debug.DeleteNodePod(clientset, namespace, name); err != nil {
+			log.WithError(err).WithFields(log.Fields{"namespace": namespace, "name": name}).Errorf("Could not delete node shell pod")
+		}
+	}
+
+	reqURL, err := debug.AttachURL(config, namespace, name, debug.NodeContainerName)
+	if err != nil {
+		deletePod()
+		log.WithError(err).Errorf("Could not create attach url")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create attach url: %s", err.Error()))
+		return
+	}
+
+	sessionID, err := terminal.GenTerminalSessionID()
+	if err != nil {
+		deletePod()
+		log.WithError(err).Errorf("Could not generate terminal session id")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not generate terminal session id: %s", err.Error()))
+		return
+	}
+
+	session := terminal.NewTerminalSession(sessionID, middleware.Identity(r), terminal.SessionInfo{
+		Type:      "node",
+		Cluster:   request.Cluster,
+		Namespace: namespace,
+		Pod:       name,
+		Container: debug.NodeContainerName,
+		Node:      request.Node,
+	})
+
+	session.Recorder, err = terminal.NewRecorder(sessionID, session.Owner, fmt.Sprintf("%s: node/%s", request.Cluster, request.Node))
+	if err != nil {
+		deletePod()
+		log.WithError(err).Errorf("Could not create recording")
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create recording: %s", err.Error()))
+		return
+	}
+
+	event := auditEvent(r, audit.EventTypeSessionStart, request.Cluster, request.ImpersonateUser, request.ImpersonateGroups)
+	event.SessionType = "node"
+	event.Method = http.MethodPost
+	event.URL = reqURL.Path
+	auditSessionEnd := c.auditSession(event, &session)
+
+	terminal.TerminalSessions.Set(sessionID, session)
+
+	go func() {
+		defer deletePod()
+		auditSessionEnd(terminal.WaitForAttach(config, reqURL, debug.AttachOptions, sessionID))
+	}()
+
+	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
+	return
+}
+
+// kubernetesLogsHandler generates the clientset and an id for streaming logs. The logs can be streamed for a single
+// container or for all containers of the Pods, which are matching a label selector or a workload. The log lines can be
+// filtered on the server and returned as plain text or as JSON.
 func (c *Client) kubernetesLogsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		middleware.Write(w, r, nil)
 		return
 	}
 
-	var request kube.Request
+	var request terminal.LogsRequest
 	if r.Body == nil {
 		log.Error("Request body is empty")
 		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
@@ -143,7 +527,14 @@ func (c *Client) kubernetesLogsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, clientset, err := c.kubeClient.GetConfigAndClientset(request.Cluster, request.URL, request.CertificateAuthorityData, request.ClientCertificateData, request.ClientKeyData, request.Token, request.Username, request.Password, request.InsecureSkipTLSVerify, 6*time.Hour, request.Proxy)
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
 	if err != nil {
 		log.WithError(err).Errorf("Could not create Kubernetes API client")
 		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
@@ -157,10 +548,160 @@ func (c *Client) kubernetesLogsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	terminal.LogSessions.Set(sessionID, terminal.LogSession{
-		ClientSet: clientset,
-		URL:       request.URL,
+	options, err := terminal.NewLogOptions(request)
+	if err != nil {
+		log.WithError(err).Errorf("Invalid log options")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Invalid log options: %s", err.Error()))
+		return
+	}
+
+	if !request.IsMulti() {
+		session := terminal.NewLogSession(clientset, request.URL, middleware.Identity(r), terminal.NewSessionInfo("logs", request.Cluster, request.URL))
+		session.Options = options
+		terminal.LogSessions.Set(sessionID, session)
+
+		middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
+		return
+	}
+
+	// When the request contains a label selector or a workload, the logs of all matching Pods and containers are
+	// streamed in the same session.
+	selector, err := terminal.NewLogSelector(r.Context(), clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create log selector")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create log selector: %s", err.Error()))
+		return
+	}
+
+	session := terminal.NewLogSession(clientset, "", middleware.Identity(r), terminal.SessionInfo{
+		Type:      "logs",
+		Cluster:   request.Cluster,
+		Namespace: selector.Namespace,
+		Selector:  selector.LabelSelector,
 	})
+	session.Selector = selector
+	session.Options = options
+	terminal.LogSessions.Set(sessionID, session)
+
+	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
+	return
+}
+
+// kubernetesLogsDownloadHandler handles the requests to download the logs of a container as plain text or gzip
+// compressed file. When the request contains a label selector or a workload, the logs of all containers of the matching
+// Pods are returned as zip archive.
+func (c *Client) kubernetesLogsDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request terminal.LogsDownloadRequest
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request.Request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	if request.IsBundle() {
+		bundle, err := terminal.NewLogsBundle(r.Context(), clientset, request)
+		if err != nil {
+			log.WithError(err).Errorf("Could not download logs")
+			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not download logs: %s", err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundle.Filename))
+		w.WriteHeader(http.StatusOK)
+
+		// The status code was already sent, so that we can only log errors and abort the response.
+		if err := bundle.WriteTo(r.Context(), w); err != nil {
+			log.WithError(err).Errorf("Could not write logs")
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	download, err := terminal.NewLogsDownload(r.Context(), clientset, request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not download logs")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not download logs: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", download.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download.Filename))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := download.WriteTo(w); err != nil {
+		log.WithError(err).Errorf("Could not write logs")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// kubernetesWatchHandler generates the clientset and an id for watching Kubernetes resources.
+func (c *Client) kubernetesWatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	var request kube.Request
+	if r.Body == nil {
+		log.Error("Request body is empty")
+		middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decode request body")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return
+	}
+
+	credentials, err := c.credentials(r, request)
+	if err != nil {
+		log.WithError(err).Errorf("Impersonation is not allowed")
+		middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, 6*time.Hour)
+	if err != nil {
+		log.WithError(err).Errorf("Could not create Kubernetes API client")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	sessionID, err := terminal.GenTerminalSessionID()
+	if err != nil {
+		log.WithError(err).Errorf("Could not generate watch session id")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not generate watch session id: %s", err.Error()))
+		return
+	}
+
+	watch.Sessions.Set(sessionID, watch.NewSession(clientset, request.URL, request.Cluster, middleware.Identity(r)))
 
 	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
 	return
@@ -186,21 +727,53 @@ func (c *Client) kubernetesSSHHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Establish the SSH connection before the terminal session is created, so that we can return authentication and host
+	// key errors to the user. When the host key is unknown and trust on first use is enabled, we return the fingerprint
+	// of the host key, so that the user can confirm it.
+	conn, err := terminal.DialSSH(request)
+	if err != nil {
+		var unknownHostKeyErr *terminal.UnknownHostKeyError
+		if errors.As(err, &unknownHostKeyErr) {
+			middleware.Write(w, r, unknownHostKeyErr.Response())
+			return
+		}
+
+		log.WithError(err).Errorf("Could not establish SSH connection")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not establish SSH connection: %s", err.Error()))
+		return
+	}
+
 	sessionID, err := terminal.GenTerminalSessionID()
 	if err != nil {
+		conn.Close()
 		log.WithError(err).Errorf("Could not generate terminal session id")
 		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not generate terminal session id: %s", err.Error()))
 		return
 	}
 
-	terminal.TerminalSessions.Set(sessionID, terminal.TerminalSession{
-		ID:       sessionID,
-		Bound:    make(chan error),
-		SizeChan: make(chan remotecommand.TerminalSize),
+	session := terminal.NewTerminalSession(sessionID, middleware.Identity(r), terminal.SessionInfo{
+		Type:    "ssh",
+		Address: request.Address,
 	})
 
-	go terminal.WaitForSSH(request.Key, request.Address, request.User, sessionID)
-	time.Sleep(1 * time.Second)
+	event := auditEvent(r, audit.EventTypeSessionStart, "", "", nil)
+	event.SessionType = "ssh"
+	event.URL = fmt.Sprintf("ssh://%s@%s", request.User, request.Address)
+
+	session.Recorder, err = terminal.NewRecorder(sessionID, session.Owner, event.URL)
+	if err != nil {
+		conn.Close()
+		log.WithError(err).Errorf("Could not create recording")
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create recording: %s", err.Error()))
+		return
+	}
+	auditSessionEnd := c.auditSession(event, &session)
+
+	terminal.TerminalSessions.Set(sessionID, session)
+
+	go func() {
+		auditSessionEnd(terminal.WaitForSSH(conn, sessionID))
+	}()
 
 	middleware.Write(w, r, terminal.TerminalResponse{ID: sessionID})
 	return
@@ -211,8 +784,10 @@ func (c *Client) kubernetesSSHHandler(w http.ResponseWriter, r *http.Request) {
 //   - POST: Initialize a new port forwarding session.
 //   - DELETE: Delete a port forwarding session.
 func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.Request) {
-	// GET returns all active port forwarding sessions. We filter the active sessions to exclude the sessions needed for
-	// plugins.
+	// GET returns all active port forwarding sessions with their state, the number of forwarded connections and bytes.
+	// We filter the active sessions to exclude the sessions needed for plugins. Failed sessions are returned until they
+	// are removed, so that the user can see the error. Like for the session management API only the sessions, which
+	// can be managed by the user are returned.
 	if r.Method == http.MethodGet {
 		var sessions []portforwarding.PortForwarding
 
@@ -220,14 +795,8 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 		defer portforwarding.Sessions.Lock.RUnlock()
 
 		for _, session := range portforwarding.Sessions.Sessions {
-			if !strings.HasPrefix(session.ID, "plugins_") {
-				sessions = append(sessions, portforwarding.PortForwarding{
-					ID:           session.ID,
-					PodName:      session.PodName,
-					PodNamespace: session.PodNamespace,
-					PodPort:      session.PodPort,
-					LocalPort:    session.LocalPort,
-				})
+			if !strings.HasPrefix(session.ID, "plugins_") && c.canManageSession(r, session.Owner) {
+				sessions = append(sessions, session.Info())
 			}
 		}
 
@@ -236,7 +805,9 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 	}
 
 	// POST initializes a new port forwarding session and returns the session ID the pod data and the used local Port,
-	// which is randomly specified when the user choosed 0 as local pod.
+	// which is randomly specified when the user choosed 0 as local pod. When the request contains the kind and name of
+	// a Service, Deployment or StatefulSet, a ready Pod of the target is selected and the returned pod data are the data
+	// of the selected Pod.
 	if r.Method == http.MethodPost {
 		var request portforwarding.Request
 		if r.Body == nil {
@@ -251,7 +822,14 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 			return
 		}
 
-		config, _, err := c.kubeClient.GetConfigAndClientset(request.Cluster, request.URL, request.CertificateAuthorityData, request.ClientCertificateData, request.ClientKeyData, request.Token, request.Username, request.Password, request.InsecureSkipTLSVerify, time.Duration(request.Timeout)*time.Second, request.Proxy)
+		credentials, err := c.credentials(r, request.Request)
+		if err != nil {
+			log.WithError(err).Errorf("Impersonation is not allowed")
+			middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+			return
+		}
+
+		config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, time.Duration(request.Timeout)*time.Second)
 		if err != nil {
 			log.WithError(err).Errorf("Could not create Kubernetes API client")
 			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
@@ -260,7 +838,7 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 
 		// Create a new session for port forwarding and start the portforwarding request. Then we wait until the
 		// connection is ready, befor we return the request to the user.
-		pf, err := portforwarding.CreateSession("", request.PodName, request.PodNamespace, request.PodPort, request.LocalPort, config)
+		pf, err := portforwarding.CreateTargetSession(r.Context(), clientset, request.Cluster, middleware.Identity(r), request.PortForwarding, config)
 		if err != nil {
 			log.WithError(err).Errorf("Could not initialize port forwarding")
 			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not initialize port forwarding: %s", err.Error()))
@@ -284,19 +862,20 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 		case <-pf.ReadyCh:
 			log.Debug("Port forwarding is ready")
 			break
+		case <-r.Context().Done():
+			// When the request is canceled before the connection is ready, nobody knows the session, so that we have
+			// to close it.
+			log.WithError(r.Context().Err()).Debug("Port forwarding request was canceled")
+			portforwarding.Sessions.Close(pf.ID)
+			return
 		}
 
-		middleware.Write(w, r, portforwarding.PortForwarding{
-			ID:           pf.ID,
-			PodName:      request.PodName,
-			PodNamespace: request.PodNamespace,
-			PodPort:      pf.PodPort,
-			LocalPort:    pf.LocalPort,
-		})
+		middleware.Write(w, r, pf.Info())
 		return
 	}
 
-	// DELETE closes a port forwarding session by the specified session id.
+	// DELETE closes a port forwarding session by the specified session id. Sessions of other users (see
+	// canManageSession) and the sessions for plugins can not be closed.
 	if r.Method == http.MethodDelete {
 		var request portforwarding.PortForwarding
 		if r.Body == nil {
@@ -311,11 +890,15 @@ func (c *Client) kubernetesPortForwardingHandler(w http.ResponseWriter, r *http.
 			return
 		}
 
-		if session, ok := portforwarding.Sessions.Get(request.ID); ok {
-			close(session.StopCh)
-			portforwarding.Sessions.Delete(session.ID)
+		session, ok := portforwarding.Sessions.Get(request.ID)
+		if !ok || strings.HasPrefix(session.ID, "plugins_") || !c.canManageSession(r, session.Owner) {
+			log.WithFields(log.Fields{"session": request.ID}).Errorf("Session not found")
+			middleware.Errorf(w, r, nil, http.StatusNotFound, "Session not found")
+			return
 		}
 
+		portforwarding.Sessions.Close(session.ID)
+
 		middleware.Write(w, r, nil)
 		return
 	}
@@ -353,7 +936,14 @@ func (c *Client) kubernetesPluginHandler(w http.ResponseWriter, r *http.Request)
 		}
 
 		requestTimeout := time.Duration(request.Timeout) * time.Second
-		config, clientset, err := c.kubeClient.GetConfigAndClientset(request.Cluster, request.URL, request.CertificateAuthorityData, request.ClientCertificateData, request.ClientKeyData, request.Token, request.Username, request.Password, request.InsecureSkipTLSVerify, requestTimeout, request.Proxy)
+		credentials, err := c.credentials(r, request.Request)
+		if err != nil {
+			log.WithError(err).Errorf("Impersonation is not allowed")
+			middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Impersonation is not allowed: %s", err.Error()))
+			return
+		}
+
+		config, clientset, err := c.kubeClient.GetConfigAndClientset(credentials, requestTimeout)
 		if err != nil {
 			log.WithError(err).Errorf("Could not create Kubernetes API client")
 			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+)
+
+func TestCredentials(t *testing.T) {
+	extra := map[string][]string{"scopes": {"view"}}
+
+	for _, tc := range []struct {
+		name               string
+		impersonation      bool
+		request            kube.Request
+		expectError        bool
+		expectImpersonated bool
+	}{
+		{name: "no impersonation", request: kube.Request{Cluster: "cluster"}},
+		{name: "impersonation disabled", request: kube.Request{ImpersonateUser: "alice"}, expectError: true},
+		{name: "impersonate user", impersonation: true, request: kube.Request{ImpersonateUser: "alice"}, expectImpersonated: true},
+		{name: "impersonate user with extra", impersonation: true, request: kube.Request{ImpersonateUser: "alice", ImpersonateExtra: extra}, expectImpersonated: true},
+		{name: "impersonate groups without user", impersonation: true, request: kube.Request{ImpersonateGroups: []string{"admins"}}, expectError: true},
+		{name: "impersonate extra without user", impersonation: true, request: kube.Request{ImpersonateExtra: extra}, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(false, tc.impersonation, nil, nil, nil, nil, nil)
+
+			credentials, err := c.credentials(httptest.NewRequest("POST", "/api/kubernetes/request", nil), tc.request)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if credentials.IsImpersonated() != tc.expectImpersonated {
+				t.Errorf("expected impersonated %t, got %t", tc.expectImpersonated, credentials.IsImpersonated())
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TokenCookieName is the name of the cookie, which can be used instead of the Authorization header to pass the bearer
+// token to kubenav. This is required for the SockJS connections and Server Sent Events, because the browser doesn't
+// allow us to set custom headers for these requests.
+const TokenCookieName = "kubenav_token"
+
+// User is the structure of an authenticated user. The name and groups of the user are passed to the Kubernetes API via
+// impersonation, so that the RBAC rules of the cluster are applied for each user.
+type User struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+// Authenticator is the interface, which must be implemented by all authentication methods. The Authenticate method
+// returns the authenticated user for a request or an error, when the request couldn't be authenticated.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+type userKey struct{}
+
+// UserFromContext returns the authenticated user from the request context. The second return value is false, when the
+// request wasn't authenticated, because authentication is disabled.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userKey{}).(*User)
+	return user, ok
+}
+
+// Auth authenticates all requests with the given authenticator and adds the authenticated user to the request context.
+// When the authenticator is nil, authentication is disabled and all requests are passed to the next handler.
+// Preflight requests are never authenticated, because the browser doesn't send credentials for them. They are answered
+// with the configured CORS policy and never passed to the next handler. All other OPTIONS requests are authenticated.
+func Auth(authenticator Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if IsPreflight(r) {
+			Preflight(w, r)
+			return
+		}
+
+		user, err := authenticator.Authenticate(r)
+		if err != nil {
+			log.WithError(err).Debugf("Request could not be authenticated")
+			Errorf(w, r, err, http.StatusUnauthorized, fmt.Sprintf("Unauthorized: %s", err.Error()))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// TokenAuthenticator authenticates requests via static bearer tokens. The tokens are passed via the Authorization
+// header or the kubenav_token cookie.
+type TokenAuthenticator struct {
+	tokens map[string]*User
+}
+
+// NewTokenAuthenticator returns a new authenticator for static tokens. The tokens are loaded from a CSV file with the
+// same format as the static token file of the Kubernetes API server: token,user,uid,"group1,group2,group3"
+func NewTokenAuthenticator(file string) (*TokenAuthenticator, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*User)
+	for index, record := range records {
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("token file must contain at least a token and an user in line %d", index+1)
+		}
+
+		user := &User{Name: record[1]}
+		if len(record) >= 4 && record[3] != "" {
+			user.Groups = splitList(record[3])
+		}
+
+		tokens[record[0]] = user
+	}
+
+	return &TokenAuthenticator{tokens: tokens}, nil
+}
+
+// Authenticate returns the user for the token from the Authorization header or the kubenav_token cookie.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	token := BearerToken(r, TokenCookieName)
+	if token == "" {
+		return nil, fmt.Errorf("token is missing")
+	}
+
+	user, ok := a.tokens[token]
+	if !ok {
+		return nil, fmt.Errorf("token is invalid")
+	}
+
+	return user, nil
+}
+
+// HeaderAuthenticator authenticates requests via headers, which are set by an authenticating reverse proxy in front of
+// kubenav (e.g. the OAuth2 Proxy). The headers are only accepted from the configured trusted proxies.
+type HeaderAuthenticator struct {
+	userHeader     string
+	groupsHeader   string
+	trustedProxies []*net.IPNet
+}
+
+// NewHeaderAuthenticator returns a new authenticator for reverse proxy headers. The groups header can contain a comma
+// separated list of groups. The trusted proxies are a comma separated list of CIDRs. At least one trusted proxy is
+// required, because otherwise every client could set the headers and authenticate as any user.
+func NewHeaderAuthenticator(userHeader, groupsHeader, trustedProxies string) (*HeaderAuthenticator, error) {
+	if userHeader == "" {
+		return nil, fmt.Errorf("user header is required")
+	}
+
+	var proxies []*net.IPNet
+	for _, cidr := range splitList(trustedProxies) {
+		_, proxy, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		proxies = append(proxies, proxy)
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("at least one trusted proxy is required")
+	}
+
+	return &HeaderAuthenticator{
+		userHeader:     userHeader,
+		groupsHeader:   groupsHeader,
+		trustedProxies: proxies,
+	}, nil
+}
+
+// Authenticate returns the user from the configured headers, when the request was sent by a trusted proxy.
+func (a *HeaderAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	trusted := false
+	for _, proxy := range a.trustedProxies {
+		if ip != nil && proxy.Contains(ip) {
+			trusted = true
+			break
+		}
+	}
+
+	if !trusted {
+		return nil, fmt.Errorf("request was not sent by a trusted proxy")
+	}
+
+	name := r.Header.Get(a.userHeader)
+	if name == "" {
+		return nil, fmt.Errorf("user header is missing")
+	}
+
+	user := &User{Name: name}
+	if a.groupsHeader != "" {
+		for _, groups := range r.Header.Values(a.groupsHeader) {
+			user.Groups = append(user.Groups, splitList(groups)...)
+		}
+	}
+
+	return user, nil
+}
+
+// BearerToken returns the bearer token from the Authorization header of a request. If the header isn't set, the token
+// is read from the cookie with the given name.
+func BearerToken(r *http.Request, cookieName string) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// Identity returns an identifier for the client of a request. It is used to bind sessions (e.g. terminal sessions) to the
+// client which created them, so that the session id alone can not be used to hijack a session. For authenticated
+// requests the name of the user is used, otherwise the hash of the bearer token. When the request contains neither, an
+// empty string is returned. This means that the ownership checks for sessions require an authentication method: without
+// authentication all clients have the same identity and a session is only protected by its random session id, which is
+// only returned to the client that created the session.
+func Identity(r *http.Request) string {
+	if user, ok := UserFromContext(r.Context()); ok {
+		return "user:" + user.Name
+	}
+
+	if token := BearerToken(r, TokenCookieName); token != "" {
+		hash := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(hash[:])
+	}
+
+	return ""
+}
+
+// splitList splits a comma separated list and removes empty items and leading and trailing whitespaces.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
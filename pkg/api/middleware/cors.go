@@ -1,17 +1,236 @@
 package middleware
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
-// Cors sets cors headers to handles preflight requests.
+// CorsConfig is the structure of the CORS policy. The allowed origins can contain the "*" wildcard to allow all origins
+// or a wildcard for subdomains, e.g. "https://*.example.com". Requests from the same origin as kubenav are always
+// allowed.
+type CorsConfig struct {
+	AllowedOrigins   []string `yaml:"allowedOrigins"`
+	AllowedMethods   []string `yaml:"allowedMethods"`
+	AllowedHeaders   []string `yaml:"allowedHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	MaxAge           int      `yaml:"maxAge"`
+}
+
+// DefaultCorsConfig is the CORS policy, which is used when no other policy is configured. It allows requests from all
+// origins, so that the mobile and desktop implementation of kubenav are working as before.
+var DefaultCorsConfig = CorsConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"POST", "GET", "OPTIONS", "PUT", "DELETE", "PATCH"},
+	AllowedHeaders: []string{"Accept", "Authorization", "Content-Type"},
+}
+
+var corsConfig = DefaultCorsConfig
+
+// Validate returns an error, when the CORS policy allows credentials for all origins. This would allow every website to
+// send requests with the cookies of a user, e.g. the session cookie of the OIDC authenticator.
+func (c CorsConfig) Validate() error {
+	if c.AllowCredentials && allowsAllOrigins(c.AllowedOrigins) {
+		return fmt.Errorf("credentials can not be allowed for all origins (\"*\")")
+	}
+
+	return nil
+}
+
+// SetCorsConfig sets the CORS policy, which is used by the Cors and CheckOrigin middlewares. Empty fields are set to the
+// values from the DefaultCorsConfig. An error is returned, when the policy is invalid (see Validate).
+func SetCorsConfig(config CorsConfig) error {
+	if len(config.AllowedOrigins) == 0 {
+		config.AllowedOrigins = DefaultCorsConfig.AllowedOrigins
+	}
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = DefaultCorsConfig.AllowedMethods
+	}
+	if len(config.AllowedHeaders) == 0 {
+		config.AllowedHeaders = DefaultCorsConfig.AllowedHeaders
+	}
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	corsConfig = config
+	return nil
+}
+
+// LoadCorsConfig loads the CORS policy from a YAML file. The values from the file are merged with the given config, so
+// that the command-line flags can be used to set the policy when no file is provided.
+func LoadCorsConfig(file string, config CorsConfig) (CorsConfig, error) {
+	if file == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("could not parse cors config: %w", err)
+	}
+
+	return config, config.Validate()
+}
+
+// Cors sets cors headers to handles preflight requests. Requests from origins which are not allowed by the configured
+// CORS policy are rejected.
 func Cors(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
+
+		if !setCorsHeaders(w, r) {
+			return
+		}
+
+		if IsPreflight(r) {
+			answerPreflight(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsPreflight returns true, when the request is a CORS preflight request. A preflight request is an OPTIONS request,
+// which contains the "Origin" and the "Access-Control-Request-Method" header.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight answers a CORS preflight request with the configured CORS policy, without calling any other handler.
+func Preflight(w http.ResponseWriter, r *http.Request) {
+	if setCorsHeaders(w, r) {
+		answerPreflight(w, r)
+	}
+}
+
+// setCorsHeaders sets the CORS headers for the origin of the request. It returns false and writes an error, when the
+// origin isn't allowed by the configured CORS policy.
+func setCorsHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin != "" {
+		if !isAllowedOrigin(r, origin) {
+			log.WithFields(log.Fields{"origin": origin}).Debugf("Origin is not allowed")
+			Errorf(w, r, nil, http.StatusForbidden, "Origin is not allowed")
+			return false
+		}
+
+		// Credentials are never allowed for the "*" wildcard, so that the origin of the request is only reflected
+		// when it is explicitly allowed (see CorsConfig.Validate).
+		if allowsAllOrigins(corsConfig.AllowedOrigins) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+
+			if corsConfig.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsConfig.AllowedHeaders, ", "))
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsConfig.AllowedMethods, ", "))
+
+	return true
+}
+
+// answerPreflight answers a preflight request. The requested method must be allowed by the configured policy.
+func answerPreflight(w http.ResponseWriter, r *http.Request) {
+	if !containsMethod(corsConfig.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+		Errorf(w, r, nil, http.StatusForbidden, "Method is not allowed")
+		return
+	}
+
+	if corsConfig.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsConfig.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CheckOrigin rejects all requests from origins which are not allowed by the configured CORS policy. In contrast to the
+// Cors middleware no headers are set, so that it can be used for handlers which are setting the CORS headers on their
+// own, like the SockJS handlers.
+func CheckOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AllowedOrigin(r) {
+			log.WithFields(log.Fields{"origin": r.Header.Get("Origin")}).Debugf("Origin is not allowed")
+			Errorf(w, r, nil, http.StatusForbidden, "Origin is not allowed")
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AllowedOrigin returns true when the request has no origin or when the origin is allowed by the configured CORS policy.
+// It is passed to the WebSocket handlers to check the origin during the upgrade of the connection.
+func AllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || isAllowedOrigin(r, origin)
+}
+
+// isAllowedOrigin returns true when the origin is the same as the host of the request or when it is allowed by the
+// configured CORS policy.
+func isAllowedOrigin(r *http.Request, origin string) bool {
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+
+	return containsOrigin(corsConfig.AllowedOrigins, origin)
+}
+
+// containsOrigin checks if the origin matches one of the allowed origins. An allowed origin can contain a single "*"
+// wildcard, which matches any value.
+func containsOrigin(allowedOrigins []string, origin string) bool {
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || allowedOrigin == origin {
+			return true
+		}
+
+		if index := strings.Index(allowedOrigin, "*"); index != -1 {
+			prefix := allowedOrigin[:index]
+			suffix := allowedOrigin[index+1:]
+
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allowsAllOrigins returns true when the allowed origins contain the "*" wildcard.
+func allowsAllOrigins(allowedOrigins []string) bool {
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsMethod checks if the method is one of the allowed methods.
+func containsMethod(allowedMethods []string, method string) bool {
+	for _, allowedMethod := range allowedMethods {
+		if strings.EqualFold(allowedMethod, method) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContainsOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		expected       bool
+	}{
+		{name: "wildcard", allowedOrigins: []string{"*"}, origin: "https://kubenav.io", expected: true},
+		{name: "exact match", allowedOrigins: []string{"https://kubenav.io"}, origin: "https://kubenav.io", expected: true},
+		{name: "no match", allowedOrigins: []string{"https://kubenav.io"}, origin: "https://example.com", expected: false},
+		{name: "subdomain wildcard", allowedOrigins: []string{"https://*.kubenav.io"}, origin: "https://app.kubenav.io", expected: true},
+		{name: "subdomain wildcard with other domain", allowedOrigins: []string{"https://*.kubenav.io"}, origin: "https://app.example.com", expected: false},
+		{name: "subdomain wildcard with other scheme", allowedOrigins: []string{"https://*.kubenav.io"}, origin: "http://app.kubenav.io", expected: false},
+		{name: "prefix and suffix overlap", allowedOrigins: []string{"https://a*a.io"}, origin: "https://a.io", expected: false},
+		{name: "port wildcard", allowedOrigins: []string{"http://localhost:*"}, origin: "http://localhost:8100", expected: true},
+		{name: "second origin", allowedOrigins: []string{"https://kubenav.io", "capacitor://localhost"}, origin: "capacitor://localhost", expected: true},
+		{name: "no allowed origins", allowedOrigins: nil, origin: "https://kubenav.io", expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := containsOrigin(tc.allowedOrigins, tc.origin); actual != tc.expected {
+				t.Errorf("containsOrigin(%v, %q) = %t, expected %t", tc.allowedOrigins, tc.origin, actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCorsConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		config      CorsConfig
+		expectError bool
+	}{
+		{name: "default config", config: DefaultCorsConfig, expectError: false},
+		{name: "wildcard without credentials", config: CorsConfig{AllowedOrigins: []string{"*"}}, expectError: false},
+		{name: "wildcard with credentials", config: CorsConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, expectError: true},
+		{name: "wildcard in list with credentials", config: CorsConfig{AllowedOrigins: []string{"https://kubenav.io", "*"}, AllowCredentials: true}, expectError: true},
+		{name: "explicit origins with credentials", config: CorsConfig{AllowedOrigins: []string{"https://kubenav.io"}, AllowCredentials: true}, expectError: false},
+		{name: "subdomain wildcard with credentials", config: CorsConfig{AllowedOrigins: []string{"https://*.kubenav.io"}, AllowCredentials: true}, expectError: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectError && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	if err := SetCorsConfig(CorsConfig{AllowedOrigins: []string{"https://kubenav.io"}}); err != nil {
+		t.Fatalf("could not set cors config: %v", err)
+	}
+	defer SetCorsConfig(DefaultCorsConfig)
+
+	for _, tc := range []struct {
+		name     string
+		origin   string
+		expected bool
+	}{
+		{name: "no origin", origin: "", expected: true},
+		{name: "same origin", origin: "http://localhost:14122", expected: true},
+		{name: "allowed origin", origin: "https://kubenav.io", expected: true},
+		{name: "other origin", origin: "https://example.com", expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://localhost:14122/api/kubernetes/exec/ws", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+
+			if actual := AllowedOrigin(r); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
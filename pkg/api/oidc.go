@@ -98,9 +98,7 @@ func (c *Client) oidcGetLinkHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	oidcRequest.Scopes = strings.ReplaceAll(oidcRequest.Scopes, " ", "")
-	scopes := strings.Split(oidcRequest.Scopes, ",")
-	scopes = append(scopes, oidc.ScopeOpenID)
+	scopes := oidcScopes(oidcRequest.Scopes)
 
 	oauth2Config := oauth2.Config{
 		ClientID:     oidcRequest.ClientID,
@@ -171,9 +169,7 @@ func (c *Client) oidcGetRefreshTokenHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	oidcRequest.Scopes = strings.ReplaceAll(oidcRequest.Scopes, " ", "")
-	scopes := strings.Split(oidcRequest.Scopes, ",")
-	scopes = append(scopes, oidc.ScopeOpenID)
+	scopes := oidcScopes(oidcRequest.Scopes)
 
 	oauth2Config := oauth2.Config{
 		ClientID:     oidcRequest.ClientID,
@@ -241,9 +237,7 @@ func (c *Client) oidcGetAccessTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	oidcRequest.Scopes = strings.ReplaceAll(oidcRequest.Scopes, " ", "")
-	scopes := strings.Split(oidcRequest.Scopes, ",")
-	scopes = append(scopes, oidc.ScopeOpenID)
+	scopes := oidcScopes(oidcRequest.Scopes)
 
 	oauth2Config := oauth2.Config{
 		ClientID:     oidcRequest.ClientID,
@@ -271,6 +265,13 @@ func (c *Client) oidcGetAccessTokenHandler(w http.ResponseWriter, r *http.Reques
 	return
 }
 
+// oidcScopes returns the scopes for the OIDC provider from a comma separated list of scopes. The "openid" scope is
+// always added to the returned scopes.
+func oidcScopes(scopes string) []string {
+	scopes = strings.ReplaceAll(scopes, " ", "")
+	return append(strings.Split(scopes, ","), oidc.ScopeOpenID)
+}
+
 // oidcContext creates the context for the HTTP requests against the OIDC provider. If the OIDC provider uses a self
 // signed certificate, it will be included in the context.
 //
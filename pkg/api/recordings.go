@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kubernetesRecordingsHandler returns all recordings of exec and SSH sessions, which can be accessed by the user of the
+// request (see canManageSession). Recording must be enabled via the "recordings-dir" flag.
+func (c *Client) kubernetesRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	recordings, err := terminal.ListRecordings()
+	if err != nil {
+		log.WithError(err).Errorf("Could not list recordings")
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not list recordings: %s", err.Error()))
+		return
+	}
+
+	var allowed []terminal.Recording
+	for _, recording := range recordings {
+		if c.canManageSession(r, recording.Owner) {
+			allowed = append(allowed, recording)
+		}
+	}
+
+	middleware.Write(w, r, allowed)
+}
+
+// kubernetesRecordingHandler returns a single recording in the asciicast format. The id of the recording is the last
+// part of the request path. Recordings of other users are handled as not found, when the user isn't an admin.
+func (c *Client) kubernetesRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	params := strings.Split(r.URL.Path, "/")
+	id := params[len(params)-1]
+
+	file, err := terminal.OpenRecording(id)
+	if err != nil {
+		log.WithError(err).Errorf("Could not open recording")
+		code := http.StatusBadRequest
+		if os.IsNotExist(err) {
+			code = http.StatusNotFound
+		}
+		middleware.Errorf(w, r, err, code, fmt.Sprintf("Could not open recording: %s", err.Error()))
+		return
+	}
+	defer file.Close()
+
+	if header, err := terminal.ReadRecordingHeader(id); err != nil || !c.canManageSession(r, header.Owner) {
+		log.WithFields(log.Fields{"recording": id}).Errorf("Recording can not be accessed by the user")
+		middleware.Errorf(w, r, err, http.StatusNotFound, "Recording not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.cast\"", id))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.WithError(err).Errorf("Could not write recording")
+	}
+}
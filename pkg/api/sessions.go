@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+	"github.com/kubenav/kubenav/pkg/audit"
+	"github.com/kubenav/kubenav/pkg/handlers/portforwarding"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/handlers/watch"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Session is the structure of a single session, which is returned by the session management API. Bound is set, when a
+// client is connected to the session. The state is only set for port forwarding sessions, which are not bound to a
+// client connection.
+type Session struct {
+	ID string `json:"id"`
+	terminal.SessionInfo
+	Owner        string    `json:"owner,omitempty"`
+	Created      time.Time `json:"created"`
+	LastActivity time.Time `json:"lastActivity,omitempty"`
+	Bound        bool      `json:"bound"`
+	State        string    `json:"state,omitempty"`
+	BytesIn      int64     `json:"bytesIn"`
+	BytesOut     int64     `json:"bytesOut"`
+}
+
+// SessionRequest is the structure of a request to terminate a session.
+type SessionRequest struct {
+	ID string `json:"id"`
+}
+
+// canManageSession returns true, when the user of the request is allowed to see and terminate a session of the given
+// owner. When authentication is disabled, all sessions can be managed. Otherwise users can only manage their own
+// sessions, except members of the configured admin groups, which can manage all sessions.
+func (c *Client) canManageSession(r *http.Request, owner string) bool {
+	return c.terminalClient(r).CanAccess(owner)
+}
+
+// terminalClient returns the client of a request for the terminal handlers. The identity is used to check the owner of
+// terminal sessions and recordings. When authentication is disabled, every client is an admin.
+func (c *Client) terminalClient(r *http.Request) terminal.Client {
+	client := terminal.Client{Identity: middleware.Identity(r), Admin: c.authenticator == nil}
+
+	if user, ok := middleware.UserFromContext(r.Context()); ok && !client.Admin {
+		for _, group := range user.Groups {
+			for _, adminGroup := range c.adminGroups {
+				if group == adminGroup {
+					client.Admin = true
+				}
+			}
+		}
+	}
+
+	return client
+}
+
+// sessions returns all active terminal, log, watch and port forwarding sessions, which can be managed by the user of the
+// request. Port forwarding sessions for plugins are not returned, because they are only used internally.
+func (c *Client) sessions(r *http.Request) []Session {
+	var sessions []Session
+
+	terminal.TerminalSessions.Lock.RLock()
+	for _, session := range terminal.TerminalSessions.Sessions {
+		if c.canManageSession(r, session.Owner) {
+			sessions = append(sessions, Session{
+				ID:           session.ID,
+				SessionInfo:  session.Info,
+				Owner:        session.Owner,
+				Created:      session.Created,
+				LastActivity: session.LastActivity(),
+				Bound:        session.Conn != nil,
+				BytesIn:      session.BytesIn(),
+				BytesOut:     session.BytesOut(),
+			})
+		}
+	}
+	terminal.TerminalSessions.Lock.RUnlock()
+
+	terminal.LogSessions.Lock.RLock()
+	for sessionID, session := range terminal.LogSessions.Sessions {
+		if c.canManageSession(r, session.Owner) {
+			sessions = append(sessions, Session{
+				ID:          sessionID,
+				SessionInfo: session.Info,
+				Owner:       session.Owner,
+				Created:     session.Created,
+				Bound:       session.Bound,
+				BytesOut:    session.BytesOut(),
+			})
+		}
+	}
+	terminal.LogSessions.Lock.RUnlock()
+
+	watch.Sessions.Lock.RLock()
+	for sessionID, session := range watch.Sessions.Sessions {
+		if c.canManageSession(r, session.Owner) {
+			sessions = append(sessions, Session{
+				ID:          sessionID,
+				SessionInfo: terminal.NewSessionInfo("watch", session.Cluster, session.URL),
+				Owner:       session.Owner,
+				Created:     session.Created,
+				Bound:       session.Bound,
+			})
+		}
+	}
+	watch.Sessions.Lock.RUnlock()
+
+	portforwarding.Sessions.Lock.RLock()
+	for _, session := range portforwarding.Sessions.Sessions {
+		if !strings.HasPrefix(session.ID, "plugins_") && c.canManageSession(r, session.Owner) {
+			info := session.Info()
+			sessions = append(sessions, Session{
+				ID: session.ID,
+				SessionInfo: terminal.SessionInfo{
+					Type:      "portforwarding",
+					Cluster:   session.Cluster,
+					Namespace: info.PodNamespace,
+					Pod:       info.PodName,
+				},
+				Owner:    session.Owner,
+				Created:  session.Created,
+				State:    info.State,
+				BytesIn:  info.BytesIn,
+				BytesOut: info.BytesOut,
+			})
+		}
+	}
+	portforwarding.Sessions.Lock.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	return sessions
+}
+
+// sessionsHandler handles all requests for the session management API.
+//   - GET: Return all active terminal, log, watch and port forwarding sessions.
+//   - DELETE: Terminate a session.
+func (c *Client) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		middleware.Write(w, r, c.sessions(r))
+		return
+	}
+
+	// DELETE terminates the session with the given id. Because the session ids are unique across all session types, we
+	// haven't to specify the type of the session.
+	if r.Method == http.MethodDelete {
+		var request SessionRequest
+		if r.Body == nil {
+			log.Error("Request body is empty")
+			middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			log.WithError(err).Errorf("Could not decode request body")
+			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+			return
+		}
+
+		var session *Session
+		for _, s := range c.sessions(r) {
+			if s.ID == request.ID {
+				session = &s
+				break
+			}
+		}
+
+		if session == nil {
+			log.WithFields(log.Fields{"session": request.ID}).Errorf("Session not found")
+			middleware.Errorf(w, r, nil, http.StatusNotFound, "Session not found")
+			return
+		}
+
+		switch session.Type {
+		case "portforwarding":
+			portforwarding.Sessions.Close(session.ID)
+		case "logs":
+			terminal.LogSessions.Close(session.ID)
+		case "watch":
+			watch.Sessions.Close(session.ID)
+		default:
+			terminal.TerminalSessions.Close(session.ID, 2, "Session was terminated")
+		}
+
+		event := auditEvent(r, audit.EventTypeSessionTerminate, session.Cluster, "", nil)
+		event.SessionID = session.ID
+		event.SessionType = session.Type
+		c.auditor.Log(event)
+
+		middleware.Write(w, r, nil)
+		return
+	}
+
+	middleware.Write(w, r, nil)
+}
@@ -0,0 +1,123 @@
+// Package audit implements the audit log for kubenav. The audit log records all mutating requests against the
+// Kubernetes API and the lifetime of all interactive sessions (exec and SSH). Each event contains the identity of the
+// user, so that it is possible to find out who deleted a Pod or who opened a shell in a container. The events are
+// written to one or more sinks, e.g. a JSON lines file, stdout or a webhook. Optionally the output of all terminal
+// sessions can be saved as transcript.
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EventTypeRequest is the type of an event for a mutating request against the Kubernetes API.
+	EventTypeRequest = "request"
+	// EventTypeSessionStart is the type of an event, which is created when an interactive session is started.
+	EventTypeSessionStart = "session.start"
+	// EventTypeSessionEnd is the type of an event, which is created when an interactive session is closed.
+	EventTypeSessionEnd = "session.end"
+	// EventTypeSessionTerminate is the type of an event, which is created when a session is terminated via the session
+	// management API.
+	EventTypeSessionTerminate = "session.terminate"
+)
+
+// Event is the structure of a single audit event. The user and groups are the authenticated user or the impersonated
+// user, when authentication is disabled. For session events the session id and type are set, the duration is only set
+// for the "session.end" event. The transcript field contains the path to the transcript of the session.
+type Event struct {
+	Time            time.Time `json:"time"`
+	Type            string    `json:"type"`
+	User            string    `json:"user,omitempty"`
+	Groups          []string  `json:"groups,omitempty"`
+	RemoteAddr      string    `json:"remoteAddr,omitempty"`
+	Cluster         string    `json:"cluster,omitempty"`
+	Method          string    `json:"method,omitempty"`
+	URL             string    `json:"url,omitempty"`
+	Code            int       `json:"code,omitempty"`
+	SessionID       string    `json:"sessionID,omitempty"`
+	SessionType     string    `json:"sessionType,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"`
+	Transcript      string    `json:"transcript,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Sink is the interface, which must be implemented by all audit sinks.
+type Sink interface {
+	Write(event Event) error
+	Close() error
+}
+
+// Logger writes audit events to all configured sinks. A nil Logger is valid and discards all events, so that the
+// audit log can be disabled without checking for nil in the API handlers.
+type Logger struct {
+	sinks         []Sink
+	transcriptDir string
+}
+
+// NewLogger returns a new audit logger for the given sinks. When the transcript directory is not empty, the output of
+// all terminal sessions is saved in this directory.
+func NewLogger(sinks []Sink, transcriptDir string) (*Logger, error) {
+	if transcriptDir != "" {
+		if err := os.MkdirAll(transcriptDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Logger{
+		sinks:         sinks,
+		transcriptDir: transcriptDir,
+	}, nil
+}
+
+// Log writes the event to all sinks. Errors are only logged, because a failing sink should not break the request of
+// the user.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(event); err != nil {
+			log.WithError(err).WithFields(log.Fields{"type": event.Type}).Errorf("Could not write audit event")
+		}
+	}
+}
+
+// Transcript creates a new transcript file for the session with the given id. It returns nil when transcripts are
+// disabled. The caller must close the returned file, when the session is closed.
+func (l *Logger) Transcript(sessionID string) (io.WriteCloser, string, error) {
+	if l == nil || l.transcriptDir == "" {
+		return nil, "", nil
+	}
+
+	name := filepath.Join(l.transcriptDir, fmt.Sprintf("%s-%s.log", time.Now().UTC().Format("20060102T150405Z"), sessionID))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, name, nil
+}
+
+// Close closes all sinks.
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			log.WithError(err).Errorf("Could not close audit sink")
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSinkClosed is returned, when an event is written to a closed sink. The event is dropped.
+var ErrSinkClosed = errors.New("audit sink is closed, event was dropped")
+
+// WriterSink writes all events as JSON lines to a writer. It is used for the file and stdout sinks.
+type WriterSink struct {
+	writer io.Writer
+	closer io.Closer
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewStdoutSink returns a sink, which writes all events as JSON lines to stdout.
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{writer: os.Stdout}
+}
+
+// NewFileSink returns a sink, which appends all events as JSON lines to the given file.
+func NewFileSink(file string) (*WriterSink, error) {
+	if file == "" {
+		return nil, fmt.Errorf("audit file is required")
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriterSink{writer: f, closer: f}, nil
+}
+
+// Write writes the event as single JSON line.
+func (s *WriterSink) Write(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+
+	_, err = s.writer.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the underlying file. The stdout sink is never closed, but all later events are dropped.
+func (s *WriterSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// WebhookSink sends all events as JSON to a webhook. The events are sent in the background, so that a slow webhook
+// doesn't block the requests of the users. When the queue is full or the sink is closed, new events are dropped.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	events chan Event
+	done   chan struct{}
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewWebhookSink returns a sink, which sends all events via a POST request to the given url.
+func NewWebhookSink(url string) (*WebhookSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+
+	s := &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan Event, 1000),
+		done:   make(chan struct{}),
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Write adds the event to the queue of the webhook sink. The lock ensures that no event is sent on the closed queue.
+func (s *WebhookSink) Write(event Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, event was dropped")
+	}
+}
+
+// Close stops accepting new events and waits until all queued events are sent.
+func (s *WebhookSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.lock.Unlock()
+
+	<-s.done
+	return nil
+}
+
+// run sends all queued events to the webhook.
+func (s *WebhookSink) run() {
+	defer close(s.done)
+
+	for event := range s.events {
+		if err := s.send(event); err != nil {
+			log.WithError(err).WithFields(log.Fields{"type": event.Type}).Errorf("Could not send audit event to webhook")
+		}
+	}
+}
+
+// send sends a single event to the webhook.
+func (s *WebhookSink) send(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
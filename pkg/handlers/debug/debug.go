@@ -0,0 +1,171 @@
+// Package debug implements the creation of ephemeral debug containers. Ephemeral containers can be added to a running
+// Pod via the "ephemeralcontainers" subresource, e.g. to debug Pods with distroless images, which do not contain a
+// shell. After the container is running, a terminal session can be attached to the container.
+// NOTE: The implementation requires Kubernetes 1.22 or newer, because older versions are using the EphemeralContainers
+// kind instead of the Pod kind for the subresource.
+package debug
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultImage is the image, which is used for the debug container when the request doesn't contain an image.
+const DefaultImage = "busybox:latest"
+
+// AttachOptions are the options to attach to a debug container. All debug containers are using stdin and a tty.
+var AttachOptions = terminal.AttachOptions{Stdin: true, TTY: true}
+
+// Request is the structure of a request to create an ephemeral debug container. It contains the standard fields for
+// each request against the Kubernetes API, the namespace and name of the Pod, the image for the debug container and
+// the name of the container, whose process namespace should be shared with the debug container.
+type Request struct {
+	kube.Request
+	Namespace           string   `json:"namespace"`
+	Name                string   `json:"name"`
+	Image               string   `json:"image"`
+	TargetContainerName string   `json:"targetContainerName"`
+	Command             []string `json:"command"`
+}
+
+// CreateContainer adds a new ephemeral container to the Pod and waits until the container is running. It returns the
+// name of the created container.
+func CreateContainer(ctx context.Context, clientset *kubernetes.Clientset, request Request) (string, error) {
+	if request.Namespace == "" || request.Name == "" {
+		return "", fmt.Errorf("namespace and name of the pod are required")
+	}
+
+	image := request.Image
+	if image == "" {
+		image = DefaultImage
+	}
+
+	name, err := containerName()
+	if err != nil {
+		return "", err
+	}
+
+	// The ephemeral container is added via a strategic merge patch, so that we do not overwrite ephemeral containers,
+	// which were added in the meantime. The container must use stdin and a tty, so that we can attach to it.
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+					Name:                     name,
+					Image:                    image,
+					Command:                  request.Command,
+					ImagePullPolicy:          corev1.PullIfNotPresent,
+					Stdin:                    true,
+					TTY:                      true,
+					TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+				},
+				TargetContainerName: request.TargetContainerName,
+			}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = clientset.CoreV1().Pods(request.Namespace).Patch(ctx, request.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "ephemeralcontainers")
+	if err != nil {
+		return "", err
+	}
+
+	if err := waitForContainer(ctx, clientset, request.Namespace, request.Name, name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// AttachURL returns the URL to attach to the given container of a Pod. The host of the URL is the host from the rest
+// config, so that the URL can be used for the SPDY executor.
+func AttachURL(config *rest.Config, namespace, name, container string) (*url.URL, error) {
+	u, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path.Join(u.Path, "api", "v1", "namespaces", namespace, "pods", name, "attach")
+	u.RawQuery = AttachOptions.Query(container).Encode()
+
+	return u, nil
+}
+
+// waitForContainer polls the status of the Pod until the (ephemeral) container is running. An error is returned when
+// the container was terminated or when it is waiting because of an error, e.g. when the image can not be pulled.
+func waitForContainer(ctx context.Context, clientset *kubernetes.Clientset, namespace, name, container string) error {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		statuses := append(pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses...)
+		for _, status := range statuses {
+			if status.Name != container {
+				continue
+			}
+
+			if status.State.Running != nil {
+				return nil
+			}
+
+			if status.State.Terminated != nil {
+				return fmt.Errorf("debug container was terminated: %s", status.State.Terminated.Reason)
+			}
+
+			if status.State.Waiting != nil {
+				switch status.State.Waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerError", "CreateContainerConfigError":
+					return fmt.Errorf("debug container could not be started: %s: %s", status.State.Waiting.Reason, status.State.Waiting.Message)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("debug container was not started in time: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// containerName returns a random name for a debug container.
+func containerName() (string, error) {
+	suffix, err := randomSuffix()
+	if err != nil {
+		return "", err
+	}
+
+	return "debugger-" + suffix, nil
+}
+
+// randomSuffix returns a random suffix for the names of debug containers and Pods.
+func randomSuffix() (string, error) {
+	bytes := make([]byte, 3)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,129 @@
+package debug
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// NodeContainerName is the name of the container in the Pod for a node shell.
+	NodeContainerName = "debugger"
+	// nodeHostPath is the path in the container, where the root filesystem of the node is mounted.
+	nodeHostPath = "/host"
+)
+
+// NodeRequest is the structure of a request to open a shell on a node. The shell is started in a privileged Pod, which
+// is scheduled on the node. The Pod is created in the given namespace or in the "default" namespace.
+type NodeRequest struct {
+	kube.Request
+	Node      string `json:"node"`
+	Namespace string `json:"namespace"`
+	Image     string `json:"image"`
+}
+
+// CreateNodePod creates a privileged Pod on the node from the request and waits until the container of the Pod is
+// running. The Pod uses the PID, network and IPC namespace of the node and mounts the root filesystem of the node, so
+// that the shell can chroot into the host. It returns the namespace and name of the created Pod.
+func CreateNodePod(ctx context.Context, clientset *kubernetes.Clientset, request NodeRequest) (string, string, error) {
+	if request.Node == "" {
+		return "", "", fmt.Errorf("name of the node is required")
+	}
+
+	namespace := request.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	image := request.Image
+	if image == "" {
+		image = DefaultImage
+	}
+
+	suffix, err := randomSuffix()
+	if err != nil {
+		return "", "", err
+	}
+
+	privileged := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("node-%s-%s", nodePodName(request.Node), suffix),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "kubenav",
+				"kubenav.io/node-shell":        "true",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      request.Node,
+			HostPID:       true,
+			HostNetwork:   true,
+			HostIPC:       true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			// The Pod must tolerate all taints, so that it can also be scheduled on nodes, which are not ready or
+			// reserved for special workloads.
+			Tolerations: []corev1.Toleration{{
+				Operator: corev1.TolerationOpExists,
+			}},
+			Containers: []corev1.Container{{
+				Name:                     NodeContainerName,
+				Image:                    image,
+				ImagePullPolicy:          corev1.PullIfNotPresent,
+				Command:                  []string{"chroot", nodeHostPath, "/bin/sh", "-c", "if [ -x /bin/bash ]; then exec /bin/bash -l; else exec /bin/sh -l; fi"},
+				Stdin:                    true,
+				StdinOnce:                true,
+				TTY:                      true,
+				TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+				SecurityContext: &corev1.SecurityContext{
+					Privileged: &privileged,
+				},
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      "host-root",
+					MountPath: nodeHostPath,
+				}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "host-root",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{Path: "/"},
+				},
+			}},
+		},
+	}
+
+	pod, err = clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := waitForContainer(ctx, clientset, namespace, pod.Name, NodeContainerName); err != nil {
+		DeleteNodePod(clientset, namespace, pod.Name)
+		return "", "", err
+	}
+
+	return namespace, pod.Name, nil
+}
+
+// DeleteNodePod deletes the Pod for a node shell. It uses its own context, because the Pod must also be deleted when the
+// request which created the Pod was canceled.
+func DeleteNodePod(clientset *kubernetes.Clientset, namespace, name string) error {
+	gracePeriod := int64(0)
+	return clientset.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+}
+
+// nodePodName returns a name for the Pod, which is derived from the node name. The name of the node is truncated, so
+// that the name of the Pod is a valid DNS label.
+func nodePodName(node string) string {
+	name := strings.ToLower(strings.Split(node, ".")[0])
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,152 @@
+package files
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// maxTrailingSize is the maximum number of bytes, which are discarded after the last read entry of an archive.
+const maxTrailingSize = 1024 * 1024
+
+// Download is a running download of a file or directory from a container. When the path is a regular file, the content
+// of the file is returned. When the path is a directory, a sanitized tar archive of the directory is returned.
+type Download struct {
+	Name string
+	File bool
+	Size int64
+
+	first  *tar.Header
+	reader *tar.Reader
+	pipe   *io.PipeReader
+	cancel context.CancelFunc
+	errCh  chan error
+	once   sync.Once
+	err    error
+}
+
+// NewDownload starts the download for the request. It reads the first entry of the archive, so that errors of the tar
+// command (e.g. when the path doesn't exist) are returned before the caller writes the response. The caller must call
+// WriteTo or Close, to release the resources of the download.
+func NewDownload(ctx context.Context, config *rest.Config, clientset *kubernetes.Clientset, request Request) (*Download, error) {
+	if _, err := Command(request, false); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+
+	d := &Download{
+		Name:   path.Base(path.Clean(request.Path)),
+		pipe:   pr,
+		cancel: cancel,
+		errCh:  make(chan error, 1),
+	}
+
+	go func() {
+		err := Stream(ctx, config, clientset, request, nil, pw)
+		pw.CloseWithError(err)
+		d.errCh <- err
+	}()
+
+	d.reader = tar.NewReader(pr)
+	header, err := d.reader.Next()
+	if err != nil {
+		if streamErr := d.wait(false); streamErr != nil {
+			return nil, streamErr
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found", request.Path)
+		}
+		return nil, err
+	}
+
+	d.first = header
+	if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+		if name, ok := SanitizeName(header.Name); ok && name == d.Name {
+			d.File = true
+			d.Size = header.Size
+		}
+	}
+
+	if d.File && d.Size > maxSize {
+		d.wait(true)
+		return nil, ErrSizeLimit
+	}
+
+	return d, nil
+}
+
+// WriteTo writes the content of the file or the sanitized tar archive to the writer. It returns the number of bytes of
+// all copied files.
+func (d *Download) WriteTo(w io.Writer) (int64, error) {
+	if d.File {
+		n, err := io.Copy(w, d.reader)
+		if err != nil {
+			d.wait(true)
+			return n, err
+		}
+		return n, d.wait(false)
+	}
+
+	tw := tar.NewWriter(w)
+
+	size, err := copyEntry(tw, d.reader, d.first, maxSize)
+	if err == errSkipEntry {
+		err = nil
+	}
+	if err == nil {
+		var n int64
+		_, n, err = CopyArchive(tw, d.reader, maxSize-size)
+		size += n
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		d.wait(true)
+		return size, err
+	}
+
+	return size, d.wait(false)
+}
+
+// Close aborts the download.
+func (d *Download) Close() error {
+	d.wait(true)
+	return nil
+}
+
+// wait waits until the tar command is finished and returns the error of the command. When abort is true, the command
+// is canceled. Otherwise the remaining data (e.g. the padding at the end of the archive) is discarded, so that the
+// command can exit.
+func (d *Download) wait(abort bool) error {
+	d.once.Do(func() {
+		if !abort {
+			if n, _ := io.CopyN(io.Discard, d.pipe, maxTrailingSize+1); n > maxTrailingSize {
+				abort = true
+			}
+		}
+
+		if abort {
+			d.pipe.Close()
+			d.cancel()
+		}
+
+		d.err = <-d.errCh
+		d.cancel()
+
+		// The error of a canceled command is ignored, because the caller already knows why the download was aborted.
+		if abort {
+			d.err = nil
+		}
+	})
+
+	return d.err
+}
@@ -0,0 +1,228 @@
+// Package files implements the copy of files to and from containers, like "kubectl cp". The files are transferred as
+// tar archive via the exec subresource, so that the container must contain the tar binary. All names in the archives
+// are sanitized, so that an archive can not write files outside of the target directory.
+package files
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// stderrLimit is the maximum number of bytes, which are captured from the stderr of the tar command.
+const stderrLimit = 64 * 1024
+
+var (
+	// maxSize is the maximum number of bytes, which can be copied to or from a container in a single request.
+	maxSize int64 = 1024 * 1024 * 1024
+
+	// ErrSizeLimit is returned when the files exceed the maximum size.
+	ErrSizeLimit = errors.New("size limit exceeded")
+)
+
+// SetMaxSize sets the maximum number of bytes, which can be copied to or from a container in a single request.
+func SetMaxSize(size int64) {
+	if size > 0 {
+		maxSize = size
+	}
+}
+
+// GetMaxSize returns the maximum number of bytes, which can be copied to or from a container in a single request.
+func GetMaxSize() int64 {
+	return maxSize
+}
+
+// Request is the structure of a request to copy files to or from a container. For a download the path is the file or
+// directory in the container, which should be downloaded. For an upload the path is the directory in the container,
+// where the uploaded files are saved.
+type Request struct {
+	kube.Request
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Path      string `json:"path"`
+}
+
+// UploadResponse is the structure of the response for an upload. It contains the directory in the container, the number
+// of files and the number of bytes, which were copied.
+type UploadResponse struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// Command returns the tar command, which is executed in the container for the request.
+func Command(request Request, upload bool) ([]string, error) {
+	if request.Namespace == "" || request.Name == "" {
+		return nil, fmt.Errorf("namespace and name of the pod are required")
+	}
+
+	if request.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	p := path.Clean(request.Path)
+
+	if upload {
+		return []string{"tar", "-x", "-m", "-f", "-", "-C", p}, nil
+	}
+
+	base := path.Base(p)
+	if base == "/" || base == "." || base == ".." {
+		return nil, fmt.Errorf("invalid path %s", request.Path)
+	}
+
+	return []string{"tar", "-c", "-f", "-", "-C", path.Dir(p), base}, nil
+}
+
+// Stream runs the tar command for the request in the container. For a download the archive is written to the stdout
+// writer, for an upload the archive is read from the stdin reader. When the command fails, the error contains the
+// output of the tar command.
+func Stream(ctx context.Context, config *rest.Config, clientset *kubernetes.Clientset, request Request, stdin io.Reader, stdout io.Writer) error {
+	command, err := Command(request, stdin != nil)
+	if err != nil {
+		return err
+	}
+
+	executor, err := terminal.NewExecutor(ctx, config, terminal.ExecURL(clientset, request.Namespace, request.Name, request.Container, command, stdin != nil))
+	if err != nil {
+		return err
+	}
+
+	stderr := terminal.NewLimitedBuffer(stderrLimit)
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    false,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w", msg, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SanitizeName returns the cleaned name of a tar entry. It returns false, when the entry would be written outside of
+// the target directory, e.g. because it is an absolute path or contains "..".
+func SanitizeName(name string) (string, bool) {
+	if name == "" || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", false
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+
+	return cleaned, true
+}
+
+// isSafeLink returns true, when the target of a symbolic or hard link in a tar entry is inside of the target directory.
+func isSafeLink(header *tar.Header, name string) bool {
+	if header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeLink {
+		return true
+	}
+
+	if path.IsAbs(header.Linkname) {
+		return false
+	}
+
+	// The target of a hard link is relative to the target directory, the target of a symbolic link is relative to the
+	// directory of the link.
+	target := header.Linkname
+	if header.Typeflag == tar.TypeSymlink {
+		target = path.Join(path.Dir(name), header.Linkname)
+	}
+
+	_, ok := SanitizeName(target)
+	return ok
+}
+
+// CopyArchive copies all entries from the source archive to the destination archive. Entries with names outside of the
+// target directory or links pointing outside of the target directory are skipped. Only regular files, directories and
+// links are copied. It returns the number of copied files and bytes. ErrSizeLimit is returned, when the size of all
+// files exceeds the limit.
+func CopyArchive(dst *tar.Writer, src *tar.Reader, limit int64) (int, int64, error) {
+	var files int
+	var size int64
+
+	for {
+		header, err := src.Next()
+		if err == io.EOF {
+			return files, size, nil
+		}
+		if err != nil {
+			return files, size, err
+		}
+
+		if _, err := copyEntry(dst, src, header, limit-size); err != nil {
+			if err == errSkipEntry {
+				continue
+			}
+			return files, size, err
+		}
+
+		files++
+		size += header.Size
+	}
+}
+
+// errSkipEntry is returned by copyEntry, when the entry was skipped.
+var errSkipEntry = errors.New("entry skipped")
+
+// copyEntry copies a single entry to the destination archive. The header is modified, so that it only contains the
+// sanitized name and the fields which are required to extract the entry.
+func copyEntry(dst *tar.Writer, src io.Reader, header *tar.Header, limit int64) (int64, error) {
+	name, ok := SanitizeName(header.Name)
+	if !ok || !isSafeLink(header, name) {
+		return 0, errSkipEntry
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return 0, errSkipEntry
+	}
+
+	if header.Size > limit {
+		return 0, ErrSizeLimit
+	}
+
+	if header.Typeflag == tar.TypeLink {
+		header.Linkname = path.Clean(header.Linkname)
+	}
+
+	err := dst.WriteHeader(&tar.Header{
+		Typeflag: header.Typeflag,
+		Name:     name,
+		Linkname: header.Linkname,
+		Size:     header.Size,
+		Mode:     header.Mode & 0777,
+		ModTime:  header.ModTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(dst, src)
+}
@@ -0,0 +1,162 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+		ok       bool
+	}{
+		{name: "file", input: "file.txt", expected: "file.txt", ok: true},
+		{name: "nested file", input: "dir/file.txt", expected: "dir/file.txt", ok: true},
+		{name: "trailing slash", input: "dir/", expected: "dir", ok: true},
+		{name: "dot prefix", input: "./dir/file.txt", expected: "dir/file.txt", ok: true},
+		{name: "parent inside target", input: "dir/../file.txt", expected: "file.txt", ok: true},
+		{name: "empty", input: "", ok: false},
+		{name: "dot", input: ".", ok: false},
+		{name: "parent", input: "..", ok: false},
+		{name: "parent prefix", input: "../file.txt", ok: false},
+		{name: "parent after clean", input: "dir/../../file.txt", ok: false},
+		{name: "absolute", input: "/etc/passwd", ok: false},
+		{name: "backslash", input: "..\\file.txt", ok: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, ok := SanitizeName(tc.input)
+			if ok != tc.ok || actual != tc.expected {
+				t.Errorf("SanitizeName(%q) = (%q, %t), expected (%q, %t)", tc.input, actual, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+}
+
+func TestIsSafeLink(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   *tar.Header
+		expected bool
+	}{
+		{name: "regular file", header: &tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, expected: true},
+		{name: "symlink in same directory", header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "file.txt"}, expected: true},
+		{name: "symlink to parent inside target", header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "../file.txt"}, expected: true},
+		{name: "symlink outside of target", header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "../file.txt"}, expected: false},
+		{name: "absolute symlink", header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"}, expected: false},
+		{name: "hard link inside target", header: &tar.Header{Typeflag: tar.TypeLink, Name: "dir/link", Linkname: "file.txt"}, expected: true},
+		{name: "hard link relative to target", header: &tar.Header{Typeflag: tar.TypeLink, Name: "dir/link", Linkname: "../file.txt"}, expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name, _ := SanitizeName(tc.header.Name)
+			if actual := isSafeLink(tc.header, name); actual != tc.expected {
+				t.Errorf("isSafeLink(%q -> %q) = %t, expected %t", tc.header.Name, tc.header.Linkname, actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCopyArchive(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		entries       []*tar.Header
+		limit         int64
+		expectedNames []string
+		expectedSize  int64
+		expectedErr   error
+	}{
+		{
+			name: "safe entries",
+			entries: []*tar.Header{
+				{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755},
+				{Typeflag: tar.TypeReg, Name: "dir/file.txt", Size: 4, Mode: 0644},
+				{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "file.txt"},
+			},
+			limit:         1024,
+			expectedNames: []string{"dir", "dir/file.txt", "dir/link"},
+			expectedSize:  4,
+		},
+		{
+			name: "unsafe entries are skipped",
+			entries: []*tar.Header{
+				{Typeflag: tar.TypeReg, Name: "../escape.txt", Size: 4, Mode: 0644},
+				{Typeflag: tar.TypeReg, Name: "/etc/passwd", Size: 4, Mode: 0644},
+				{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"},
+				{Typeflag: tar.TypeChar, Name: "device"},
+				{Typeflag: tar.TypeReg, Name: "file.txt", Size: 4, Mode: 0644},
+			},
+			limit:         1024,
+			expectedNames: []string{"file.txt"},
+			expectedSize:  4,
+		},
+		{
+			name: "size limit",
+			entries: []*tar.Header{
+				{Typeflag: tar.TypeReg, Name: "first.txt", Size: 4, Mode: 0644},
+				{Typeflag: tar.TypeReg, Name: "second.txt", Size: 4, Mode: 0644},
+			},
+			limit:         6,
+			expectedNames: []string{"first.txt"},
+			expectedSize:  4,
+			expectedErr:   ErrSizeLimit,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var src bytes.Buffer
+			tw := tar.NewWriter(&src)
+			for _, header := range tc.entries {
+				if err := tw.WriteHeader(header); err != nil {
+					t.Fatalf("could not write header: %v", err)
+				}
+				if header.Size > 0 {
+					if _, err := tw.Write(bytes.Repeat([]byte("a"), int(header.Size))); err != nil {
+						t.Fatalf("could not write content: %v", err)
+					}
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("could not close source archive: %v", err)
+			}
+
+			var dst bytes.Buffer
+			dw := tar.NewWriter(&dst)
+			files, size, err := CopyArchive(dw, tar.NewReader(&src), tc.limit)
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err := dw.Close(); err != nil {
+				t.Fatalf("could not close destination archive: %v", err)
+			}
+
+			if files != len(tc.expectedNames) || size != tc.expectedSize {
+				t.Errorf("expected %d files with %d bytes, got %d files with %d bytes", len(tc.expectedNames), tc.expectedSize, files, size)
+			}
+
+			var names []string
+			tr := tar.NewReader(&dst)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("could not read destination archive: %v", err)
+				}
+				names = append(names, header.Name)
+			}
+
+			if len(names) != len(tc.expectedNames) {
+				t.Fatalf("expected entries %v, got %v", tc.expectedNames, names)
+			}
+			for i := range names {
+				if names[i] != tc.expectedNames[i] {
+					t.Errorf("expected entries %v, got %v", tc.expectedNames, names)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package files
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Upload copies the files from the multipart reader to the directory from the request in the container. Parts with the
+// name "file" are saved with the base name of their file name. Parts with the name "archive" must be tar archives, which
+// are extracted in the directory. All other parts are ignored.
+func Upload(ctx context.Context, config *rest.Config, clientset *kubernetes.Clientset, request Request, reader *multipart.Reader) (*UploadResponse, error) {
+	if _, err := Command(request, true); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+
+	go func() {
+		err := Stream(ctx, config, clientset, request, pr, nil)
+		pr.CloseWithError(err)
+		errCh <- err
+	}()
+
+	response := &UploadResponse{Path: path.Clean(request.Path)}
+	tw := tar.NewWriter(pw)
+
+	err := writeParts(tw, reader, response)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		pw.CloseWithError(err)
+		cancel()
+		<-errCh
+		return nil, err
+	}
+
+	pw.Close()
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// writeParts writes all files from the multipart reader to the tar archive.
+func writeParts(tw *tar.Writer, reader *multipart.Reader, response *UploadResponse) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch part.FormName() {
+		case "file":
+			n, err := writeFile(tw, part, maxSize-response.Size)
+			if err != nil {
+				return err
+			}
+			response.Files++
+			response.Size += n
+		case "archive":
+			files, n, err := CopyArchive(tw, tar.NewReader(part), maxSize-response.Size)
+			if err != nil {
+				return err
+			}
+			response.Files += files
+			response.Size += n
+		}
+
+		part.Close()
+	}
+}
+
+// writeFile writes a single file to the tar archive. The file is saved in a temporary file first, because the size of
+// the file must be known before the file can be added to the archive.
+func writeFile(tw *tar.Writer, part *multipart.Part, limit int64) (int64, error) {
+	name, ok := SanitizeName(path.Base(part.FileName()))
+	if !ok {
+		return 0, fmt.Errorf("invalid file name %s", part.FileName())
+	}
+
+	file, err := ioutil.TempFile("", "kubenav-upload-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := io.Copy(file, io.LimitReader(part, limit+1))
+	if err != nil {
+		return 0, err
+	}
+	if size > limit {
+		return 0, ErrSizeLimit
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(tw, file)
+}
@@ -49,7 +49,7 @@ func Run(request Request, config *rest.Config, clientset *kubernetes.Clientset,
 	var result interface{}
 
 	if request.Address == "" {
-		pf, err := portforwarding.CreateSession("plugins_", "Unknow", "Unknow", request.Port, 0, config)
+		pf, err := portforwarding.CreateSession("plugins_", request.Cluster, "", "Unknow", "Unknow", request.Port, 0, config)
 		if err != nil {
 			return nil, err
 		}
@@ -1,22 +1,23 @@
 package portforwarding
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"net"
-	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kubenav/kubenav/pkg/kube"
 
+	log "github.com/sirupsen/logrus"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 )
 
 // Request is the structure of a request to initalize the port forwarding. It contains the standard fields for each
@@ -27,24 +28,82 @@ type Request struct {
 }
 
 // PortForwarding contains all additional fields required for the port forwarding. It contains the session id which can
-// be used to close the opened port and the port number.
+// be used to close the opened port and the port number. The kind, name and port fields are used to forward to a
+// Service, Deployment or StatefulSet in the namespace of the pod namespace field. The port is the name or number of the
+// port of the Service or of the container port. The pod name and port are then set to the selected Pod.
+// The remaining fields are only set in responses and contain the state of the session, the last error, the number of
+// reconnects and forwarded connections, the number and the last error of failed forwarded connections and the number
+// of bytes sent to (in) and received from (out) the Pod.
 type PortForwarding struct {
-	ID           string `json:"id"`
-	PodName      string `json:"podName"`
-	PodNamespace string `json:"podNamespace"`
-	PodPort      int64  `json:"podPort"`
-	LocalPort    int64  `json:"localPort"`
+	ID               string `json:"id"`
+	PodName          string `json:"podName"`
+	PodNamespace     string `json:"podNamespace"`
+	PodPort          int64  `json:"podPort"`
+	LocalPort        int64  `json:"localPort"`
+	Kind             string `json:"kind,omitempty"`
+	Name             string `json:"name,omitempty"`
+	Port             string `json:"port,omitempty"`
+	State            string `json:"state,omitempty"`
+	Error            string `json:"error,omitempty"`
+	Reconnects       int64  `json:"reconnects"`
+	Connections      int64  `json:"connections"`
+	ConnectionErrors int64  `json:"connectionErrors"`
+	ConnectionError  string `json:"connectionError,omitempty"`
+	BytesIn          int64  `json:"bytesIn"`
+	BytesOut         int64  `json:"bytesOut"`
 }
 
 // Session is the structure for an establish port forwading session. Additionally to the required fields for a port
 // forwarding request it contains the rest config for the Kubernetes API, a channel to close the connection, a channel
-// which can be used to check if the connection is ready and the IO streams.
+// which can be used to check if the connection is ready and the IO streams. The cluster, owner and creation time are
+// used by the session management API. The clientset is only set for sessions created via CreateTargetSession, where it
+// is used to check the selected Pod and to select another ready Pod for a Service or workload. The session is
+// supervised by Start (see supervisor.go).
 type Session struct {
 	PortForwarding
+	Cluster    string
+	Owner      string
+	Created    time.Time
 	RestConfig *rest.Config
 	StopCh     chan struct{}
 	ReadyCh    chan struct{}
 	Streams    genericclioptions.IOStreams
+
+	clientset kubernetes.Interface
+	lock      sync.RWMutex
+	failed    time.Time
+
+	reconnects       int64
+	connections      int64
+	connectionErrors int64
+	bytesIn          int64
+	bytesOut         int64
+}
+
+// Info returns the port forwarding fields of the session, including the state and the statistics. It must be used
+// instead of the embedded fields, because the Pod of a session for a Service or workload can be changed while the
+// session is running.
+func (s *Session) Info() PortForwarding {
+	s.lock.RLock()
+	pf := s.PortForwarding
+	s.lock.RUnlock()
+
+	pf.Reconnects = atomic.LoadInt64(&s.reconnects)
+	pf.Connections = atomic.LoadInt64(&s.connections)
+	pf.ConnectionErrors = atomic.LoadInt64(&s.connectionErrors)
+	pf.BytesIn = atomic.LoadInt64(&s.bytesIn)
+	pf.BytesOut = atomic.LoadInt64(&s.bytesOut)
+
+	return pf
+}
+
+// setPod sets the selected Pod and port of the session.
+func (s *Session) setPod(name string, port int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.PodName = name
+	s.PodPort = port
 }
 
 // SessionMap stores a map of all PortForwardSession objects and a lock to avoid concurrent conflict.
@@ -79,6 +138,53 @@ func (sm *SessionMap) Delete(sessionID string) {
 	}
 }
 
+// Close stops the port forwarding session with the given id and removes it from the active sessions. It returns false,
+// when the session doesn't exist.
+func (sm *SessionMap) Close(sessionID string) bool {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok {
+		return false
+	}
+
+	close(session.StopCh)
+	delete(sm.Sessions, sessionID)
+	return true
+}
+
+// CloseAll stops all port forwarding sessions and removes them from the active sessions.
+func (sm *SessionMap) CloseAll() {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	for sessionID, session := range sm.Sessions {
+		close(session.StopCh)
+		delete(sm.Sessions, sessionID)
+	}
+}
+
+// Reap removes all sessions, which are failed for longer than the given timeout. Failed sessions are kept for some
+// time, so that the user can see why the session was stopped.
+func (sm *SessionMap) Reap(failedTimeout time.Duration) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	now := time.Now()
+
+	for sessionID, session := range sm.Sessions {
+		session.lock.RLock()
+		failed := session.failed
+		session.lock.RUnlock()
+
+		if !failed.IsZero() && now.Sub(failed) > failedTimeout {
+			log.WithFields(log.Fields{"session": sessionID}).Infof("Remove failed port forwarding session")
+			delete(sm.Sessions, sessionID)
+		}
+	}
+}
+
 // Sessions holds all active port forwarding sessions.
 var Sessions = SessionMap{Sessions: make(map[string]*Session)}
 
@@ -96,9 +202,10 @@ func genSessionID() (string, error) {
 
 // CreateSession creates the session. For that we need the config for the Kubernetes cluster and the fields for the
 // PortForwarding struct. For the session ID we pass in a prefix, which can be used to filter the sessions, so that we
-// do not show sessions for plugins to the user.
+// do not show sessions for plugins to the user. The cluster and owner (see middleware.Identity) are only used to show
+// the session in the session management API.
 // If the user do not specify a local port we randomly generate a port for the portforwarding request.
-func CreateSession(sessionPrefix, podName, podNamespace string, podPort, localPort int64, restConfig *rest.Config) (*Session, error) {
+func CreateSession(sessionPrefix, cluster, owner, podName, podNamespace string, podPort, localPort int64, restConfig *rest.Config) (*Session, error) {
 	if localPort == 0 {
 		listener, err := net.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
@@ -124,41 +231,75 @@ func CreateSession(sessionPrefix, podName, podNamespace string, podPort, localPo
 	streams := genericclioptions.IOStreams{}
 
 	pf := &Session{
-		PortForwarding{
+		PortForwarding: PortForwarding{
 			ID:           sessionID,
 			PodName:      podName,
 			PodNamespace: podNamespace,
 			PodPort:      podPort,
 			LocalPort:    localPort,
 		},
-		restConfig,
-		stopCh,
-		readyCh,
-		streams,
+		Cluster:    cluster,
+		Owner:      owner,
+		Created:    time.Now(),
+		RestConfig: restConfig,
+		StopCh:     stopCh,
+		ReadyCh:    readyCh,
+		Streams:    streams,
 	}
+	pf.State = StateConnecting
 
 	Sessions.Set(sessionID, pf)
 
 	return pf, nil
 }
 
-// Start starts the port forwarding request, with the data saved in the session.
-func (s *Session) Start(path string) error {
-	if path == "" {
-		path = fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", s.PodNamespace, s.PodName)
+// CreateTargetSession creates the session for a Pod, Service, Deployment or StatefulSet. For a Service or workload it
+// selects a ready Pod of the target, which is used for the port forwarding. The clientset is used to check the Pod
+// while the session is running.
+func CreateTargetSession(ctx context.Context, clientset kubernetes.Interface, cluster, owner string, target PortForwarding, restConfig *rest.Config) (*Session, error) {
+	kind, err := normalizeKind(target.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == KindPod {
+		if target.PodName == "" {
+			target.PodName = target.Name
+		}
+		if target.PodPort == 0 && target.Port != "" {
+			if target.PodPort, err = strconv.ParseInt(target.Port, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid port %s", target.Port)
+			}
+		}
+		pf, err := CreateSession("", cluster, owner, target.PodName, target.PodNamespace, target.PodPort, target.LocalPort, restConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		pf.lock.Lock()
+		pf.Kind = KindPod
+		pf.clientset = clientset
+		pf.lock.Unlock()
+
+		return pf, nil
 	}
-	hostIP := strings.TrimLeft(s.RestConfig.Host, "htps:/")
 
-	transport, upgrader, err := spdy.RoundTripperFor(s.RestConfig)
+	podName, podPort, err := resolveTarget(ctx, clientset, target.PodNamespace, kind, target.Name, target.Port)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, &url.URL{Scheme: "https", Path: path, Host: hostIP})
-	fw, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", s.LocalPort, s.PodPort)}, s.StopCh, s.ReadyCh, s.Streams.Out, s.Streams.ErrOut)
+	pf, err := CreateSession("", cluster, owner, podName, target.PodNamespace, podPort, target.LocalPort, restConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return fw.ForwardPorts()
+	pf.lock.Lock()
+	pf.Kind = kind
+	pf.Name = target.Name
+	pf.Port = target.Port
+	pf.clientset = clientset
+	pf.lock.Unlock()
+
+	return pf, nil
 }
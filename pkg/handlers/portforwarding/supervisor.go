@@ -0,0 +1,399 @@
+package portforwarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	// StateConnecting is the state of a session, while the connection to the Pod is established.
+	StateConnecting = "connecting"
+	// StateReady is the state of a session, when the local port is forwarded to the Pod.
+	StateReady = "ready"
+	// StateFailed is the state of a session, when the connection to the Pod could not be established again.
+	StateFailed = "failed"
+
+	// podCheckInterval is the interval in which the selected Pod of a session is checked.
+	podCheckInterval = 5 * time.Second
+	// resolveTimeout is the timeout to check the Pod or to select another Pod before a reconnect.
+	resolveTimeout = 30 * time.Second
+	// minReconnectDelay and maxReconnectDelay are the bounds of the exponential backoff between reconnects.
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+	// maxReconnectAttempts is the number of failed reconnects, after which a session is marked as failed.
+	maxReconnectAttempts = 10
+)
+
+// errLostConnection is returned, when the connection to the Pod was closed without an error.
+var errLostConnection = errors.New("lost connection to pod")
+
+// Start starts the port forwarding request, with the data saved in the session, and supervises it until the session is
+// stopped. When the connection to the Pod is lost or the Pod isn't available anymore, the port forwarding is
+// reconnected with an exponential backoff. A failed forwarded connection doesn't interrupt the port forwarding, it is
+// only counted in the statistics of the session. For a Service or workload another ready Pod is
+// selected before the reconnect. When the first connection fails, the session is removed and the error is returned.
+// When the reconnects are failing, the session is marked as failed and removed by SessionMap.Reap.
+func (s *Session) Start(path string) error {
+	readyCh := s.ReadyCh
+	attempts := 0
+
+	for {
+		err := s.run(path, readyCh)
+		if isClosed(s.StopCh) {
+			return nil
+		}
+
+		if !isClosed(s.ReadyCh) {
+			s.setState(StateFailed, err)
+			Sessions.Delete(s.ID)
+			return err
+		}
+
+		if isClosed(readyCh) {
+			attempts = 0
+		}
+
+		log.WithError(err).WithFields(log.Fields{"session": s.ID, "pod": s.Info().PodName}).Warnf("Port forwarding was interrupted")
+
+		for {
+			attempts++
+			if attempts > maxReconnectAttempts {
+				log.WithError(err).WithFields(log.Fields{"session": s.ID}).Errorf("Port forwarding failed")
+				s.setState(StateFailed, err)
+				return err
+			}
+
+			s.setState(StateConnecting, err)
+
+			select {
+			case <-s.StopCh:
+				return nil
+			case <-time.After(reconnectDelay(attempts)):
+			}
+
+			atomic.AddInt64(&s.reconnects, 1)
+
+			if err = s.resolve(); err == nil {
+				break
+			}
+
+			log.WithError(err).WithFields(log.Fields{"session": s.ID}).Warnf("Could not select pod for port forwarding")
+		}
+
+		readyCh = make(chan struct{})
+	}
+}
+
+// run forwards the local port to the currently selected Pod, until the session is stopped, the connection is lost or
+// the Pod isn't available anymore. It returns the reason why the forwarding was stopped.
+func (s *Session) run(path string, readyCh chan struct{}) error {
+	pf := s.Info()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var goneCh <-chan struct{}
+	if s.clientset != nil {
+		goneCh = s.waitForPodGone(ctx, pf)
+	}
+
+	stopCh := make(chan struct{})
+	reasonCh := make(chan error, 1)
+
+	go func(readyCh chan struct{}) {
+		defer close(stopCh)
+
+		for {
+			select {
+			case <-readyCh:
+				s.setState(StateReady, nil)
+				readyCh = nil
+			case <-s.StopCh:
+				return
+			case <-ctx.Done():
+				return
+			case <-goneCh:
+				sendReason(reasonCh, fmt.Errorf("pod %s is not available anymore", pf.PodName))
+				return
+			}
+		}
+	}(readyCh)
+
+	err := s.forward(path, pf, stopCh, readyCh)
+	cancel()
+	<-stopCh
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case reason := <-reasonCh:
+		return reason
+	default:
+		return errLostConnection
+	}
+}
+
+// forward forwards the local port to the Pod, until the stop channel is closed or the connection is lost. The
+// connection to the Kubernetes API is tracked, to count the forwarded connections, the failed connections and the
+// bytes.
+func (s *Session) forward(path string, pf PortForwarding, stopCh, readyCh chan struct{}) error {
+	if path == "" {
+		path = fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", pf.PodNamespace, pf.PodName)
+	}
+	hostIP := strings.TrimLeft(s.RestConfig.Host, "htps:/")
+
+	transport, upgrader, err := spdy.RoundTripperFor(s.RestConfig)
+	if err != nil {
+		return err
+	}
+
+	dialer := &trackedDialer{
+		Dialer:  spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, &url.URL{Scheme: "https", Path: path, Host: hostIP}),
+		session: s,
+	}
+
+	fw, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", pf.LocalPort, pf.PodPort)}, stopCh, readyCh, s.Streams.Out, s.Streams.ErrOut)
+	if err != nil {
+		return err
+	}
+
+	return fw.ForwardPorts()
+}
+
+// waitForPodGone returns a channel, which is closed when the Pod is deleted or terminating. For a Service or workload
+// the channel is also closed, when the Pod isn't ready anymore, so that another Pod is selected. The Pod is checked until
+// the context is canceled.
+func (s *Session) waitForPodGone(ctx context.Context, pf PortForwarding) <-chan struct{} {
+	goneCh := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(podCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pod, err := s.clientset.CoreV1().Pods(pf.PodNamespace).Get(ctx, pf.PodName, metav1.GetOptions{})
+				if err != nil && !kerrors.IsNotFound(err) {
+					continue
+				}
+
+				if err != nil || !isPodAvailable(pf.Kind, pod) {
+					close(goneCh)
+					return
+				}
+			}
+		}
+	}()
+
+	return goneCh
+}
+
+// resolve checks the Pod of the session before a reconnect. For a Service or workload another ready Pod is selected.
+// Sessions without a clientset are reconnected to the same Pod without a check.
+func (s *Session) resolve() error {
+	if s.clientset == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	pf := s.Info()
+
+	if pf.Kind == "" || pf.Kind == KindPod {
+		pod, err := s.clientset.CoreV1().Pods(pf.PodNamespace).Get(ctx, pf.PodName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if !isPodAvailable(pf.Kind, pod) {
+			return fmt.Errorf("pod %s is not running", pf.PodName)
+		}
+
+		return nil
+	}
+
+	podName, podPort, err := resolveTarget(ctx, s.clientset, pf.PodNamespace, pf.Kind, pf.Name, pf.Port)
+	if err != nil {
+		return err
+	}
+
+	if podName != pf.PodName {
+		log.WithFields(log.Fields{"session": s.ID, "pod": podName, "port": podPort}).Infof("Port forwarding re-targeted")
+	}
+
+	s.setPod(podName, podPort)
+	return nil
+}
+
+// isPodAvailable returns true, when the Pod can be used for the port forwarding. A single Pod must only be running, so
+// that a session isn't interrupted by a failing readiness probe. For a Service or workload the Pod must also be ready.
+func isPodAvailable(kind string, pod *corev1.Pod) bool {
+	if kind == "" || kind == KindPod {
+		return pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning
+	}
+
+	return isPodReady(pod)
+}
+
+// setState sets the state of the session and the error, which caused the state. The error is reset, when the session
+// is ready.
+func (s *Session) setState(state string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.State = state
+
+	if err != nil {
+		s.Error = err.Error()
+	} else if state == StateReady {
+		s.Error = ""
+	}
+
+	if state == StateFailed {
+		s.failed = time.Now()
+	}
+}
+
+// connectionFailed counts a failed forwarded connection and saves the error as last connection error.
+func (s *Session) connectionFailed(err error) {
+	atomic.AddInt64(&s.connectionErrors, 1)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ConnectionError = err.Error()
+}
+
+// reconnectDelay returns the delay before the given reconnect attempt.
+func reconnectDelay(attempt int) time.Duration {
+	delay := minReconnectDelay
+	for i := 1; i < attempt && delay < maxReconnectDelay; i++ {
+		delay = delay * 2
+	}
+
+	if delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+
+	return delay
+}
+
+// sendReason sends the reason, why the forwarding was stopped, without blocking. Only the first reason is kept.
+func sendReason(reasonCh chan error, err error) {
+	select {
+	case reasonCh <- err:
+	default:
+	}
+}
+
+// isClosed returns true, when the channel is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackedDialer wraps the dialer for the port forwarding, so that the returned connection is tracked.
+type trackedDialer struct {
+	httpstream.Dialer
+	session *Session
+}
+
+// Dial dials the Kubernetes API and returns a tracked connection.
+func (d *trackedDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &trackedConnection{Connection: conn, session: d.session}, protocol, nil
+}
+
+// trackedConnection counts the forwarded connections and the bytes of all data streams. An error returned via an error
+// stream only affects a single forwarded connection, e.g. when nothing is listening on the port, so that it is only
+// counted as failed connection. The port forwarding is only reconnected, when the connection itself is closed.
+type trackedConnection struct {
+	httpstream.Connection
+	session *Session
+}
+
+// CreateStream creates a new stream and wraps the data and error streams.
+func (c *trackedConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch headers.Get(corev1.StreamType) {
+	case corev1.StreamTypeData:
+		atomic.AddInt64(&c.session.connections, 1)
+		return &dataStream{Stream: stream, session: c.session}, nil
+	case corev1.StreamTypeError:
+		return &errorStream{Stream: stream, session: c.session}, nil
+	default:
+		return stream, nil
+	}
+}
+
+// dataStream counts the bytes sent to (in) and received from (out) the Pod.
+type dataStream struct {
+	httpstream.Stream
+	session *Session
+}
+
+// Read reads from the Pod.
+func (s *dataStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	atomic.AddInt64(&s.session.bytesOut, int64(n))
+	return n, err
+}
+
+// Write writes to the Pod.
+func (s *dataStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	atomic.AddInt64(&s.session.bytesIn, int64(n))
+	return n, err
+}
+
+// errorStream counts a failed forwarded connection, when the error stream returns an error message. The message can
+// be returned in multiple reads, so that it is saved when the stream is closed.
+type errorStream struct {
+	httpstream.Stream
+	session *Session
+	message []byte
+}
+
+// Read reads the error message from the Pod.
+func (s *errorStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.message = append(s.message, p[:n]...)
+
+	if err != nil && len(s.message) > 0 {
+		s.session.connectionFailed(fmt.Errorf("%s", strings.TrimSpace(string(s.message))))
+		s.message = nil
+	}
+
+	return n, err
+}
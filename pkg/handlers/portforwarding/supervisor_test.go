@@ -0,0 +1,104 @@
+package portforwarding
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	for _, tc := range []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 1 * time.Second},
+		{attempt: 1, expected: 1 * time.Second},
+		{attempt: 2, expected: 2 * time.Second},
+		{attempt: 3, expected: 4 * time.Second},
+		{attempt: 4, expected: 8 * time.Second},
+		{attempt: 5, expected: 16 * time.Second},
+		{attempt: 6, expected: 30 * time.Second},
+		{attempt: maxReconnectAttempts, expected: 30 * time.Second},
+		{attempt: 1000, expected: 30 * time.Second},
+	} {
+		if actual := reconnectDelay(tc.attempt); actual != tc.expected {
+			t.Errorf("reconnectDelay(%d) = %s, expected %s", tc.attempt, actual, tc.expected)
+		}
+	}
+}
+
+func TestIsPodAvailable(t *testing.T) {
+	now := metav1.Now()
+
+	running := corev1.PodStatus{Phase: corev1.PodRunning}
+	ready := corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}}
+
+	for _, tc := range []struct {
+		name     string
+		kind     string
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{name: "running pod", kind: KindPod, pod: &corev1.Pod{Status: running}, expected: true},
+		{name: "running pod without kind", kind: "", pod: &corev1.Pod{Status: running}, expected: true},
+		{name: "pending pod", kind: KindPod, pod: &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}, expected: false},
+		{name: "terminating pod", kind: KindPod, pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}, Status: running}, expected: false},
+		{name: "running pod of service", kind: KindService, pod: &corev1.Pod{Status: running}, expected: false},
+		{name: "ready pod of service", kind: KindService, pod: &corev1.Pod{Status: ready}, expected: true},
+		{name: "terminating pod of deployment", kind: KindDeployment, pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}, Status: ready}, expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isPodAvailable(tc.kind, tc.pod); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// testStream is a stream for tests, which returns the data of the reader.
+type testStream struct {
+	io.Reader
+}
+
+func (s *testStream) Write(p []byte) (int, error) { return len(p), nil }
+func (s *testStream) Close() error                { return nil }
+func (s *testStream) Reset() error                { return nil }
+func (s *testStream) Headers() http.Header        { return http.Header{} }
+func (s *testStream) Identifier() uint32          { return 0 }
+
+func TestErrorStream(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		message          string
+		expectedErrors   int64
+		expectedErrorMsg string
+	}{
+		{name: "no error", message: "", expectedErrors: 0},
+		{name: "error", message: "connection refused\n", expectedErrors: 1, expectedErrorMsg: "connection refused"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &Session{}
+
+			// The error message is read byte by byte, so that a message returned in multiple reads is counted once.
+			stream := &errorStream{Stream: &testStream{Reader: iotest.OneByteReader(strings.NewReader(tc.message))}, session: session}
+			if _, err := ioutil.ReadAll(stream); err != nil {
+				t.Fatalf("could not read error stream: %v", err)
+			}
+
+			info := session.Info()
+			if info.ConnectionErrors != tc.expectedErrors || info.ConnectionError != tc.expectedErrorMsg {
+				t.Errorf("expected %d errors (%q), got %d errors (%q)", tc.expectedErrors, tc.expectedErrorMsg, info.ConnectionErrors, info.ConnectionError)
+			}
+			if info.State != "" || info.Error != "" {
+				t.Errorf("expected the session to be unchanged, got state %q and error %q", info.State, info.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package portforwarding
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KindPod is the kind of a port forwarding session for a single Pod. This is the default, when no kind is set.
+	KindPod = "pod"
+	// KindService is the kind of a port forwarding session for a Service.
+	KindService = "service"
+	// KindDeployment is the kind of a port forwarding session for a Deployment.
+	KindDeployment = "deployment"
+	// KindStatefulSet is the kind of a port forwarding session for a StatefulSet.
+	KindStatefulSet = "statefulset"
+)
+
+// normalizeKind returns the kind of the port forwarding target for all supported names and abbreviations, like they
+// can be used with "kubectl port-forward".
+func normalizeKind(kind string) (string, error) {
+	switch strings.ToLower(kind) {
+	case "", "po", "pod", "pods":
+		return KindPod, nil
+	case "svc", "service", "services":
+		return KindService, nil
+	case "deploy", "deployment", "deployments":
+		return KindDeployment, nil
+	case "sts", "statefulset", "statefulsets":
+		return KindStatefulSet, nil
+	default:
+		return "", fmt.Errorf("unsupported kind %s", kind)
+	}
+}
+
+// resolveTarget selects a ready Pod for the Service, Deployment or StatefulSet and returns the name of the Pod and the
+// port in the Pod. The port can be a number or the name of a port. For a Service it is the port of the Service, which
+// is mapped to the target port of the selected Pod, like in "kubectl port-forward svc/...".
+func resolveTarget(ctx context.Context, clientset kubernetes.Interface, namespace, kind, name, port string) (string, int64, error) {
+	var selector *metav1.LabelSelector
+	var service *corev1.Service
+
+	switch kind {
+	case KindService:
+		var err error
+		service, err = clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		if len(service.Spec.Selector) == 0 {
+			return "", 0, fmt.Errorf("service %s has no selector", name)
+		}
+		selector = &metav1.LabelSelector{MatchLabels: service.Spec.Selector}
+	case KindDeployment:
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		selector = deployment.Spec.Selector
+	case KindStatefulSet:
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		selector = statefulSet.Spec.Selector
+	default:
+		return "", 0, fmt.Errorf("unsupported kind %s", kind)
+	}
+
+	if selector == nil {
+		return "", 0, fmt.Errorf("%s %s has no selector", kind, name)
+	}
+
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", 0, err
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		return "", 0, err
+	}
+
+	pod := selectReadyPod(pods.Items)
+	if pod == nil {
+		return "", 0, fmt.Errorf("no ready pod found for %s %s", kind, name)
+	}
+
+	var podPort int64
+	if service != nil {
+		podPort, err = servicePodPort(service, pod, port)
+	} else {
+		podPort, err = containerPort(pod, port)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return pod.Name, podPort, nil
+}
+
+// selectReadyPod returns the first running and ready Pod, which isn't terminating. It returns nil, when no Pod is
+// ready.
+func selectReadyPod(pods []corev1.Pod) *corev1.Pod {
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			return &pods[i]
+		}
+	}
+
+	return nil
+}
+
+// isPodReady returns true, when the Pod is running, ready and not terminating.
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// servicePodPort returns the target port in the Pod for the given port of the Service. The port of the Service can be
+// the number or name of the port. When the Service has only one port, the port can be omitted.
+func servicePodPort(service *corev1.Service, pod *corev1.Pod, port string) (int64, error) {
+	var servicePort *corev1.ServicePort
+
+	for i, p := range service.Spec.Ports {
+		if (port == "" && len(service.Spec.Ports) == 1) || p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			servicePort = &service.Spec.Ports[i]
+			break
+		}
+	}
+
+	if servicePort == nil && port == "" {
+		return 0, fmt.Errorf("port is required, because service %s has %d ports", service.Name, len(service.Spec.Ports))
+	}
+
+	if servicePort == nil {
+		return 0, fmt.Errorf("service %s has no port %s", service.Name, port)
+	}
+
+	switch {
+	case servicePort.TargetPort.Type == intstr.String && servicePort.TargetPort.StrVal != "":
+		return containerPort(pod, servicePort.TargetPort.StrVal)
+	case servicePort.TargetPort.IntVal != 0:
+		return int64(servicePort.TargetPort.IntVal), nil
+	default:
+		return int64(servicePort.Port), nil
+	}
+}
+
+// containerPort returns the number of the port in the Pod. The port can be a number or the name of a container port.
+// When the port is omitted, the Pod must have exactly one container port.
+func containerPort(pod *corev1.Pod, port string) (int64, error) {
+	if number, err := strconv.ParseInt(port, 10, 64); err == nil {
+		return number, nil
+	}
+
+	var ports []corev1.ContainerPort
+	for _, container := range pod.Spec.Containers {
+		ports = append(ports, container.Ports...)
+	}
+
+	if port == "" {
+		if len(ports) != 1 {
+			return 0, fmt.Errorf("port is required, because pod %s has %d ports", pod.Name, len(ports))
+		}
+		return int64(ports[0].ContainerPort), nil
+	}
+
+	for _, p := range ports {
+		if p.Name == port {
+			return int64(p.ContainerPort), nil
+		}
+	}
+
+	return 0, fmt.Errorf("pod %s has no port %s", pod.Name, port)
+}
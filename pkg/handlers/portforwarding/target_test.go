@@ -0,0 +1,128 @@
+package portforwarding
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNormalizeKind(t *testing.T) {
+	for _, tc := range []struct {
+		kind        string
+		expected    string
+		expectError bool
+	}{
+		{kind: "", expected: KindPod},
+		{kind: "po", expected: KindPod},
+		{kind: "Pod", expected: KindPod},
+		{kind: "svc", expected: KindService},
+		{kind: "services", expected: KindService},
+		{kind: "deploy", expected: KindDeployment},
+		{kind: "Deployment", expected: KindDeployment},
+		{kind: "sts", expected: KindStatefulSet},
+		{kind: "daemonset", expectError: true},
+	} {
+		actual, err := normalizeKind(tc.kind)
+		if (err != nil) != tc.expectError || actual != tc.expected {
+			t.Errorf("normalizeKind(%q) = (%q, %v), expected %q", tc.kind, actual, err, tc.expected)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	labels := map[string]string{"app": "nginx"}
+
+	pod := func(name string, ready bool) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "nginx",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			}}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+			},
+		}
+	}
+
+	clientset := fake.NewSimpleClientset(
+		pod("nginx-not-ready", false),
+		pod("nginx-ready", true),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{{Name: "web", Port: 80, TargetPort: intstr.FromString("http")}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "multi", Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports: []corev1.ServicePort{
+					{Name: "web", Port: 80, TargetPort: intstr.FromInt(8080)},
+					{Name: "metrics", Port: 9090},
+				},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "external", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+	)
+
+	for _, tc := range []struct {
+		name         string
+		kind         string
+		target       string
+		port         string
+		expectedPod  string
+		expectedPort int64
+		expectError  bool
+	}{
+		{name: "service with single port", kind: KindService, target: "nginx", expectedPod: "nginx-ready", expectedPort: 8080},
+		{name: "service port by number", kind: KindService, target: "nginx", port: "80", expectedPod: "nginx-ready", expectedPort: 8080},
+		{name: "service port by name", kind: KindService, target: "multi", port: "web", expectedPod: "nginx-ready", expectedPort: 8080},
+		{name: "service port without target port", kind: KindService, target: "multi", port: "metrics", expectedPod: "nginx-ready", expectedPort: 9090},
+		{name: "service with multiple ports requires port", kind: KindService, target: "multi", expectError: true},
+		{name: "unknown service port", kind: KindService, target: "nginx", port: "443", expectError: true},
+		{name: "service without selector", kind: KindService, target: "external", expectError: true},
+		{name: "deployment with container port by name", kind: KindDeployment, target: "nginx", port: "http", expectedPod: "nginx-ready", expectedPort: 8080},
+		{name: "deployment with single container port", kind: KindDeployment, target: "nginx", expectedPod: "nginx-ready", expectedPort: 8080},
+		{name: "deployment with port number", kind: KindDeployment, target: "nginx", port: "3000", expectedPod: "nginx-ready", expectedPort: 3000},
+		{name: "unknown deployment", kind: KindDeployment, target: "unknown", expectError: true},
+		{name: "unsupported kind", kind: KindPod, target: "nginx-ready", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			podName, podPort, err := resolveTarget(context.Background(), clientset, "default", tc.kind, tc.target, tc.port)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected an error, got %s:%d", podName, podPort)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if podName != tc.expectedPod || podPort != tc.expectedPort {
+				t.Errorf("expected %s:%d, got %s:%d", tc.expectedPod, tc.expectedPort, podName, podPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// AttachOptions defines if stdin and a tty are used, when a terminal session is attached to the main process of a
+// container. The options must match the spec of the container, because the Kubernetes API rejects stdin for containers
+// without stdin and the output of a container without tty is split into stdout and stderr.
+type AttachOptions struct {
+	Stdin bool
+	TTY   bool
+}
+
+// NewAttachOptions returns the attach options for a container of a Pod. The options are derived from the stdin and tty
+// fields of the container spec. When the container name is empty, the first container of the Pod is used. It returns
+// the name of the container and the attach options.
+func NewAttachOptions(ctx context.Context, clientset *kubernetes.Clientset, namespace, name, container string) (string, AttachOptions, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", AttachOptions{}, err
+	}
+
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return container, AttachOptions{Stdin: c.Stdin, TTY: c.TTY}, nil
+		}
+	}
+
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == container {
+			return container, AttachOptions{Stdin: c.Stdin, TTY: c.TTY}, nil
+		}
+	}
+
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == container {
+			return container, AttachOptions{Stdin: c.Stdin, TTY: c.TTY}, nil
+		}
+	}
+
+	return "", AttachOptions{}, fmt.Errorf("container %s not found in pod %s", container, name)
+}
+
+// Query returns the query parameters for a "pods/attach" request to the given container.
+func (o AttachOptions) Query(container string) url.Values {
+	return url.Values{
+		"container": []string{container},
+		"stdin":     []string{strconv.FormatBool(o.Stdin)},
+		"stdout":    []string{"true"},
+		"stderr":    []string{strconv.FormatBool(!o.TTY)},
+		"tty":       []string{strconv.FormatBool(o.TTY)},
+	}
+}
+
+// startAttachProcess is called by WaitForAttach
+// Attaches to the main process of the container specified in request and connects it up with the ptyHandler (a
+// session). Only the streams which are enabled in the attach options are connected.
+func startAttachProcess(config *rest.Config, reqURL *url.URL, options AttachOptions, ptyHandler PtyHandler) error {
+	exec, err := remotecommand.NewSPDYExecutor(config, "POST", reqURL)
+	if err != nil {
+		return err
+	}
+
+	streamOptions := remotecommand.StreamOptions{
+		Stdout: ptyHandler,
+		Tty:    options.TTY,
+	}
+
+	if options.Stdin {
+		streamOptions.Stdin = ptyHandler
+	}
+
+	if options.TTY {
+		streamOptions.TerminalSizeQueue = ptyHandler
+	} else {
+		streamOptions.Stderr = ptyHandler
+
+		// Without a tty nobody reads the resize events of the client, so that we have to discard them. Otherwise reading
+		// from stdin would be blocked by the next resize event.
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			sizeChan := ptyHandler.GetSizeChan()
+			for {
+				select {
+				case <-sizeChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	return exec.Stream(streamOptions)
+}
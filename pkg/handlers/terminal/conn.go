@@ -0,0 +1,314 @@
+package terminal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/igm/sockjs-go.v2/sockjs"
+)
+
+const (
+	// wsPingPeriod is the interval in which ping messages are sent to WebSocket clients, so that idle connections are
+	// not closed by proxies.
+	wsPingPeriod = 30 * time.Second
+	// wsWriteWait is the time allowed to write a message to a WebSocket client.
+	wsWriteWait = 10 * time.Second
+	// sockJSIdentityTimeout is the time after which the saved identity of a SockJS session is removed, when the session
+	// was never opened, e.g. because the client only sent an invalid request.
+	sockJSIdentityTimeout = 1 * time.Minute
+)
+
+// Client is the client, which opened a connection. The identity is used to check the owner of sessions and recordings.
+// Admins can access the recordings of all clients.
+type Client struct {
+	Identity string
+	Admin    bool
+}
+
+// CanAccess returns true, when the client is allowed to access a resource of the given owner.
+func (c Client) CanAccess(owner string) bool {
+	return c.Admin || c.Identity == owner
+}
+
+// ClientFunc returns the client for a request. It is passed to the handlers of this package by the API, so that the
+// handlers are independent from the used authentication.
+type ClientFunc func(r *http.Request) Client
+
+// OriginFunc returns true, when the origin of a request is allowed. It is passed to the WebSocket handlers of this
+// package by the API, so that the same CORS policy is applied as for all other requests.
+type OriginFunc func(r *http.Request) bool
+
+// Conn is the connection between the frontend and a terminal session. The messages are using the TerminalMessage
+// protocol and can be sent via SockJS or a plain WebSocket connection. The client is the client, which opened the
+// connection.
+type Conn interface {
+	Recv() (TerminalMessage, error)
+	Send(msg TerminalMessage) error
+	Close(status uint32, reason string) error
+	Client() Client
+}
+
+// sockJSConn implements the Conn interface for a SockJS session. All messages are JSON encoded.
+type sockJSConn struct {
+	session sockjs.Session
+	client  Client
+	onClose func()
+}
+
+// Recv receives the next message from the SockJS session.
+func (c *sockJSConn) Recv() (TerminalMessage, error) {
+	var msg TerminalMessage
+
+	buf, err := c.session.Recv()
+	if err != nil {
+		return msg, err
+	}
+
+	err = json.Unmarshal([]byte(buf), &msg)
+	return msg, err
+}
+
+// Send sends a message to the SockJS session.
+func (c *sockJSConn) Send(msg TerminalMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.session.Send(string(data))
+}
+
+// Close closes the SockJS session.
+func (c *sockJSConn) Close(status uint32, reason string) error {
+	c.onClose()
+	return c.session.Close(status, reason)
+}
+
+// Client returns the client, which created the SockJS session.
+func (c *sockJSConn) Client() Client {
+	return c.client
+}
+
+// sockJSHandler wraps a SockJS handler. Because a SockJS session consists of multiple HTTP requests and the SockJS
+// library doesn't provide access to these requests, we save the client which created the session. All following
+// requests for the same SockJS session must be sent by the same client. The client is removed, when the connection is
+// closed or when the SockJS session wasn't opened within the sockJSIdentityTimeout.
+type sockJSHandler struct {
+	prefix     string
+	handler    http.Handler
+	client     ClientFunc
+	identities map[string]*sockJSIdentity
+	lock       sync.Mutex
+}
+
+// sockJSIdentity is the saved client, which created a SockJS session.
+type sockJSIdentity struct {
+	client  Client
+	created time.Time
+	opened  bool
+}
+
+// newSockJSHandler returns a SockJS handler, which calls the given function for all new connections. The client of a
+// connection is returned by the given ClientFunc.
+func newSockJSHandler(path string, client ClientFunc, handle func(conn Conn)) http.Handler {
+	h := &sockJSHandler{
+		prefix:     path,
+		client:     client,
+		identities: make(map[string]*sockJSIdentity),
+	}
+
+	h.handler = sockjs.NewHandler(path, sockjs.DefaultOptions, func(session sockjs.Session) {
+		var client Client
+
+		h.lock.Lock()
+		if entry, ok := h.identities[session.ID()]; ok {
+			entry.opened = true
+			client = entry.client
+		}
+		h.lock.Unlock()
+
+		handle(&sockJSConn{
+			session: session,
+			client:  client,
+			onClose: func() {
+				h.lock.Lock()
+				defer h.lock.Unlock()
+				delete(h.identities, session.ID())
+			},
+		})
+	})
+
+	return h
+}
+
+// ServeHTTP saves the client for new SockJS sessions and rejects requests for existing SockJS sessions, when they are
+// sent by another client.
+func (h *sockJSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The path of a SockJS request has the format "<prefix>/<server>/<session>/<transport>". Requests for the info and
+	// iframe endpoints are not bound to a session.
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, h.prefix+"/"), "/")
+	if len(parts) == 3 {
+		client := h.client(r)
+
+		h.lock.Lock()
+		h.prune()
+		entry, ok := h.identities[parts[1]]
+		if !ok {
+			h.identities[parts[1]] = &sockJSIdentity{client: client, created: time.Now()}
+		}
+		h.lock.Unlock()
+
+		if ok && entry.client.Identity != client.Identity {
+			log.WithFields(log.Fields{"session": parts[1]}).Errorf("SockJS session is owned by another client")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// prune removes the identities of all SockJS sessions, which were not opened within the sockJSIdentityTimeout. The
+// identities of opened sessions are removed, when the connection is closed. The caller must hold the lock of the
+// handler.
+func (h *sockJSHandler) prune() {
+	now := time.Now()
+
+	for sessionID, entry := range h.identities {
+		if !entry.opened && now.Sub(entry.created) > sockJSIdentityTimeout {
+			delete(h.identities, sessionID)
+		}
+	}
+}
+
+// wsConn implements the Conn interface for a WebSocket connection. By default all messages are JSON encoded text
+// frames. In the binary mode the output of the process is sent as raw binary frame and binary frames from the client
+// are handled as stdin, while the "bind" and "resize" messages are still sent as JSON encoded text frames.
+type wsConn struct {
+	conn      *websocket.Conn
+	binary    bool
+	client    Client
+	writeLock sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Recv receives the next message from the WebSocket connection.
+func (c *wsConn) Recv() (TerminalMessage, error) {
+	var msg TerminalMessage
+
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return msg, err
+	}
+
+	if messageType == websocket.BinaryMessage {
+		return TerminalMessage{Op: "stdin", Data: string(data)}, nil
+	}
+
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// Send sends a message to the WebSocket connection.
+func (c *wsConn) Send(msg TerminalMessage) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+
+	if c.binary && msg.Op == "stdout" {
+		return c.conn.WriteMessage(websocket.BinaryMessage, []byte(msg.Data))
+	}
+
+	return c.conn.WriteJSON(msg)
+}
+
+// Close sends a close message with the given reason to the client and closes the WebSocket connection. The status 1
+// is used for a normal closure, all other status codes are mapped to an internal server error.
+func (c *wsConn) Close(status uint32, reason string) error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		code := websocket.CloseInternalServerErr
+		if status == 1 {
+			code = websocket.CloseNormalClosure
+		}
+
+		// The reason of a close message is limited to 123 bytes.
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+
+		c.writeLock.Lock()
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+		c.writeLock.Unlock()
+
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+// Client returns the client, which opened the WebSocket connection.
+func (c *wsConn) Client() Client {
+	return c.client
+}
+
+// ping sends ping messages to the client, until the connection is closed.
+func (c *wsConn) ping() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.writeLock.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			c.writeLock.Unlock()
+
+			if err != nil {
+				log.WithError(err).Debugf("Could not send ping message")
+				return
+			}
+		}
+	}
+}
+
+// newWebSocketHandler returns a WebSocket handler, which calls the given function for all new connections. The client
+// of a connection is returned by the given ClientFunc. Connections from origins which are rejected by the given
+// OriginFunc are not upgraded. The binary mode is enabled via the "binary=true" query parameter.
+func newWebSocketHandler(client ClientFunc, checkOrigin OriginFunc, handle func(conn Conn)) http.Handler {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     checkOrigin,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.WithError(err).Errorf("Could not upgrade WebSocket connection")
+			return
+		}
+
+		c := &wsConn{
+			conn:   conn,
+			binary: r.URL.Query().Get("binary") == "true",
+			client: client(r),
+			done:   make(chan struct{}),
+		}
+
+		go c.ping()
+		handle(c)
+	})
+}
@@ -0,0 +1,52 @@
+package terminal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketHandlerOrigin(t *testing.T) {
+	checkOrigin := func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://kubenav.io"
+	}
+
+	server := httptest.NewServer(newWebSocketHandler(func(r *http.Request) Client { return Client{} }, checkOrigin, func(conn Conn) {
+		conn.Close(1, "done")
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name        string
+		origin      string
+		expectError bool
+	}{
+		{name: "allowed origin", origin: "https://kubenav.io", expectError: false},
+		{name: "other origin", origin: "https://example.com", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Origin", tc.origin)
+
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+			if tc.expectError {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("expected the connection to be rejected")
+				}
+				if resp == nil || resp.StatusCode != http.StatusForbidden {
+					t.Errorf("expected status code %d, got %v", http.StatusForbidden, resp)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			conn.Close()
+		})
+	}
+}
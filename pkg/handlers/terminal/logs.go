@@ -1,20 +1,35 @@
 package terminal
 
 import (
-	"fmt"
+	"bufio"
+	"context"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 )
 
-// LogSession is the structure of a log session, which consists of an unique id and a Kubernetes clientset.
+// LogSession is the structure of a log session, which consists of an unique id and a Kubernetes clientset. When the
+// selector is set, the logs of all matching containers are streamed, otherwise the logs for the URL are streamed. The
+// owner is the identity of the client which created the session, only this client can stream the logs. Bound is set, when the
+// client started to stream the logs. New sessions should be created via NewLogSession, so that they can be closed via
+// the session management API.
 type LogSession struct {
 	ClientSet *kubernetes.Clientset
 	URL       string
+	Selector  *LogSelector
+	Options   LogOptions
+	Owner     string
+	Info      SessionInfo
+	Created   time.Time
+	Released  time.Time
+	Bound     bool
+	done      chan struct{}
+	stats     *sessionStats
 }
 
 // LogSessionMap stores a map of all LogSession objects and a lock to avoid concurrent conflict.
@@ -39,6 +54,34 @@ func (sm *LogSessionMap) Set(sessionID string, session LogSession) {
 	sm.Sessions[sessionID] = session
 }
 
+// Bind marks the session with the given id as bound and returns the session. It returns false, when the session doesn't
+// exist, is already bound or is owned by another client.
+func (sm *LogSessionMap) Bind(sessionID, identity string) (LogSession, bool) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok || session.Bound || session.Owner != identity {
+		return LogSession{}, false
+	}
+
+	session.Bound = true
+	sm.Sessions[sessionID] = session
+	return session, true
+}
+
+// Release marks the session with the given id as unbound, so that the client can bind the session again.
+func (sm *LogSessionMap) Release(sessionID string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	if session, ok := sm.Sessions[sessionID]; ok {
+		session.Bound = false
+		session.Released = time.Now()
+		sm.Sessions[sessionID] = session
+	}
+}
+
 // Delete removes a session from the active sessions.
 func (sm *LogSessionMap) Delete(sessionID string) {
 	sm.Lock.Lock()
@@ -49,56 +92,169 @@ func (sm *LogSessionMap) Delete(sessionID string) {
 	}
 }
 
+// Close stops the log stream of the session with the given id and removes the session. It returns false, when the
+// session doesn't exist.
+func (sm *LogSessionMap) Close(sessionID string) bool {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok {
+		return false
+	}
+
+	if session.done != nil {
+		close(session.done)
+	}
+
+	delete(sm.Sessions, sessionID)
+	return true
+}
+
+// DeleteAll removes all sessions from the active sessions. Active log streams are closed via the context of the request.
+func (sm *LogSessionMap) DeleteAll() {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sm.Sessions = make(map[string]LogSession)
+}
+
 // LogSessions holds all active sessions for streamed logs.
 var LogSessions = LogSessionMap{Sessions: make(map[string]LogSession)}
 
-// StreamLogsHandler handles the requests to stream the logs of a container.
-func StreamLogsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// CreateStreamLogsHandler returns the handler for the requests to stream the logs of a container. The client of a
+// request is returned by the given ClientFunc.
+func CreateStreamLogsHandler(client ClientFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamLogs(w, r, client(r))
+	}
+}
 
+// streamLogs streams the logs of a container. Each log line is sent as single event, with the position of the line as
+// event id. When the connection is closed by the client, the session can be bound again, so that the client can resume
+// the stream via the "Last-Event-ID" header.
+func streamLogs(w http.ResponseWriter, r *http.Request, client Client) {
 	params := strings.Split(r.URL.Path, "/")
 	sessionID := params[len(params)-1]
-	logSession, ok := LogSessions.Get(sessionID)
+	logSession, ok := LogSessions.Bind(sessionID, client.Identity)
 	if !ok {
 		log.Error("Log session not found")
-		LogSessions.Delete(sessionID)
+		http.Error(w, "Log session not found", http.StatusNotFound)
 		return
 	}
 
-	readCloser, err := logSession.ClientSet.RESTClient().Get().RequestURI(logSession.URL).Stream(r.Context())
-	if err != nil {
-		LogSessions.Delete(sessionID)
+	var resume *logCursor
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		var err error
+		if resume, err = parseLogCursor(lastEventID); err != nil {
+			log.WithError(err).Errorf("Invalid Last-Event-ID header")
+			LogSessions.Release(sessionID)
+			http.Error(w, "Invalid Last-Event-ID header", http.StatusBadRequest)
+			return
+		}
+
+		if (resume.containers != nil) != (logSession.Selector != nil) {
+			log.Errorf("Last-Event-ID header doesn't match the log session")
+			LogSessions.Release(sessionID)
+			http.Error(w, "Invalid Last-Event-ID header", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	// When a max lifetime is configured, the log stream is closed when the session reaches the max lifetime. The
+	// stream is also closed, when the session is closed via the session management API.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	if maxLifetime := GetSessionConfig().MaxLifetime; maxLifetime > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, logSession.Created.Add(maxLifetime))
+		defer cancelDeadline()
+	}
+
+	if logSession.done != nil {
+		go func() {
+			select {
+			case <-logSession.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	writer := newLogEventWriter(w, logSession.Options, logSession.stats, resume, logSession.Selector != nil)
+
+	var err error
+	if logSession.Selector != nil {
+		err = streamMultiLogsHandler(ctx, writer, logSession, resumeSinceTime(logSession.Options, resume))
+	} else {
+		err = streamLogsHandler(ctx, writer, logSession, resumeSinceTime(logSession.Options, resume))
+	}
+
+	// When the client closed the connection, the session is released, so that the client can resume the stream. In all
+	// other cases the stream was finished and the session is removed.
+	if r.Context().Err() != nil {
+		log.Debugf("Log session was released")
+		LogSessions.Release(sessionID)
+		return
+	}
+
+	LogSessions.Delete(sessionID)
+
+	if err != nil && err != io.EOF && ctx.Err() == nil {
+		log.WithError(err).Errorf("Log session was closed")
 		return
 	}
+
+	log.Debugf("Log session was closed")
+}
+
+// streamLogsHandler streams the logs for the URL of the log session.
+func streamLogsHandler(ctx context.Context, writer *logEventWriter, logSession LogSession, sinceTime *time.Time) error {
+	requestURI, err := withLogTimestamps(logSession.URL, sinceTime)
+	if err != nil {
+		return err
+	}
+
+	readCloser, err := logSession.ClientSet.RESTClient().Get().RequestURI(requestURI).Stream(ctx)
+	if err != nil {
+		return err
+	}
 	defer readCloser.Close()
 
-	for {
-		select {
-		case <-r.Context().Done():
-			LogSessions.Delete(sessionID)
-			log.WithError(err).Debugf("Log session was closed")
-			return
-		default:
-			p := make([]byte, 2048)
-			n, err := readCloser.Read(p)
-			if err != nil {
-				LogSessions.Delete(sessionID)
-
-				if err == io.EOF {
-					log.WithError(err).Debugf("Log session was closed")
-					return
-				}
-
-				log.WithError(err).Errorf("Log session was closed")
-				return
-			}
+	scanner := bufio.NewScanner(readCloser)
+	scanner.Buffer(make([]byte, 4096), maxLogLineSize)
+	scanner.Split(scanLogLines)
 
-			if string(p[:n]) != "" {
-				w.Write([]byte(fmt.Sprintf("data: %s\n\n", string(p[:n]))))
-				w.(http.Flusher).Flush()
+	for scanner.Scan() {
+		if err := writer.write("", "", scanner.Text()); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// streamMultiLogsHandler streams the logs of all containers, which are matching the selector of the log session.
+func streamMultiLogsHandler(ctx context.Context, writer *logEventWriter, logSession LogSession, sinceTime *time.Time) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := streamMultiLogs(ctx, logSession.ClientSet, logSession.Selector, sinceTime)
+
+	for line := range lines {
+		if err := writer.write(line.Pod, line.Container, line.Line); err != nil {
+			cancel()
+			for range lines {
 			}
+			return err
 		}
 	}
+
+	return nil
 }
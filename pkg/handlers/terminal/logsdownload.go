@@ -0,0 +1,266 @@
+package terminal
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LogsDownloadRequest is the structure of a request to download logs. When the selector or the workload is set, the
+// logs of all containers in all matching Pods are downloaded as zip archive (bundle mode). Otherwise the logs of the
+// container of the Pod with the given name are downloaded as plain text or compressed with gzip. The previous field
+// is used to download the logs of the previous instance of a container. In the bundle mode the logs of the previous
+// instances are added to the archive, when this field is set.
+type LogsDownloadRequest struct {
+	kube.Request
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	Container    string `json:"container"`
+	Selector     string `json:"selector"`
+	WorkloadKind string `json:"workloadKind"`
+	WorkloadName string `json:"workloadName"`
+	Previous     bool   `json:"previous"`
+	SinceTime    string `json:"sinceTime"`
+	SinceSeconds int64  `json:"sinceSeconds"`
+	TailLines    int64  `json:"tailLines"`
+	LimitBytes   int64  `json:"limitBytes"`
+	Timestamps   bool   `json:"timestamps"`
+	Gzip         bool   `json:"gzip"`
+}
+
+// IsBundle returns true, when the logs of multiple Pods should be downloaded as zip archive.
+func (r LogsDownloadRequest) IsBundle() bool {
+	return r.Selector != "" || r.WorkloadName != ""
+}
+
+// podLogOptions returns the options to get the logs of a container for the request.
+func (r LogsDownloadRequest) podLogOptions(container string, previous bool) (*corev1.PodLogOptions, error) {
+	options := &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   previous,
+		Timestamps: r.Timestamps,
+	}
+
+	if r.SinceTime != "" {
+		sinceTime, err := time.Parse(time.RFC3339, r.SinceTime)
+		if err != nil {
+			return nil, err
+		}
+		options.SinceTime = &metav1.Time{Time: sinceTime}
+	} else if r.SinceSeconds > 0 {
+		options.SinceSeconds = &r.SinceSeconds
+	}
+
+	if r.TailLines > 0 {
+		options.TailLines = &r.TailLines
+	}
+
+	if r.LimitBytes > 0 {
+		options.LimitBytes = &r.LimitBytes
+	}
+
+	return options, nil
+}
+
+// LogsDownload is the download of the logs of a single container.
+type LogsDownload struct {
+	Filename    string
+	ContentType string
+
+	readCloser io.ReadCloser
+	gzip       bool
+}
+
+// NewLogsDownload opens the log stream for the container from the request. The stream is opened before the caller
+// writes the response, so that errors can be returned to the user. The caller must call WriteTo or Close.
+func NewLogsDownload(ctx context.Context, clientset *kubernetes.Clientset, request LogsDownloadRequest) (*LogsDownload, error) {
+	if request.Namespace == "" || request.Name == "" {
+		return nil, fmt.Errorf("namespace and name of the pod are required")
+	}
+
+	options, err := request.podLogOptions(request.Container, request.Previous)
+	if err != nil {
+		return nil, err
+	}
+
+	readCloser, err := clientset.CoreV1().Pods(request.Namespace).GetLogs(request.Name, options).Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := request.Name
+	if request.Container != "" {
+		filename = fmt.Sprintf("%s-%s", request.Name, request.Container)
+	}
+	if request.Previous {
+		filename = filename + "-previous"
+	}
+
+	download := &LogsDownload{
+		Filename:    filename + ".log",
+		ContentType: "text/plain; charset=utf-8",
+		readCloser:  readCloser,
+		gzip:        request.Gzip,
+	}
+
+	if request.Gzip {
+		download.Filename = download.Filename + ".gz"
+		download.ContentType = "application/gzip"
+	}
+
+	return download, nil
+}
+
+// WriteTo writes the logs to the writer and closes the log stream.
+func (d *LogsDownload) WriteTo(w io.Writer) (int64, error) {
+	defer d.readCloser.Close()
+
+	if !d.gzip {
+		return io.Copy(w, d.readCloser)
+	}
+
+	gw := gzip.NewWriter(w)
+	n, err := io.Copy(gw, d.readCloser)
+	if err != nil {
+		return n, err
+	}
+
+	return n, gw.Close()
+}
+
+// Close closes the log stream.
+func (d *LogsDownload) Close() error {
+	return d.readCloser.Close()
+}
+
+// LogsBundle is the download of the logs of all containers in multiple Pods as zip archive.
+type LogsBundle struct {
+	Filename string
+
+	clientset *kubernetes.Clientset
+	request   LogsDownloadRequest
+	pods      []corev1.Pod
+}
+
+// NewLogsBundle lists all Pods, which are matching the selector or workload from the request.
+func NewLogsBundle(ctx context.Context, clientset *kubernetes.Clientset, request LogsDownloadRequest) (*LogsBundle, error) {
+	if request.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+
+	if _, err := request.podLogOptions("", false); err != nil {
+		return nil, err
+	}
+
+	labelSelector := request.Selector
+	name := "logs"
+
+	if request.WorkloadName != "" {
+		var err error
+		labelSelector, err = workloadSelector(ctx, clientset, request.Namespace, request.WorkloadKind, request.WorkloadName)
+		if err != nil {
+			return nil, err
+		}
+		name = request.WorkloadName + "-logs"
+	}
+
+	pods, err := clientset.CoreV1().Pods(request.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pods found for selector %s", labelSelector)
+	}
+
+	return &LogsBundle{
+		Filename:  name + ".zip",
+		clientset: clientset,
+		request:   request,
+		pods:      pods.Items,
+	}, nil
+}
+
+// WriteTo writes the zip archive with the logs of all containers to the writer. Each log is saved as
+// "<pod>/<container>.log" and "<pod>/<container>.previous.log". When the logs of a container can not be retrieved, the
+// error is saved as "<pod>/<container>.error.txt", so that one failing container doesn't break the whole bundle.
+func (b *LogsBundle) WriteTo(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	for _, pod := range b.pods {
+		var containers []string
+		for _, container := range pod.Spec.InitContainers {
+			containers = append(containers, container.Name)
+		}
+		for _, container := range pod.Spec.Containers {
+			containers = append(containers, container.Name)
+		}
+
+		for _, container := range containers {
+			if err := b.writeLogs(ctx, zw, pod.Name, container, false); err != nil {
+				return err
+			}
+
+			if b.request.Previous && restartCount(pod, container) > 0 {
+				if err := b.writeLogs(ctx, zw, pod.Name, container, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeLogs adds the logs of a single container to the zip archive. Only errors of the zip writer are returned.
+func (b *LogsBundle) writeLogs(ctx context.Context, zw *zip.Writer, pod, container string, previous bool) error {
+	name := fmt.Sprintf("%s/%s", pod, container)
+	if previous {
+		name = name + ".previous"
+	}
+
+	options, _ := b.request.podLogOptions(container, previous)
+	readCloser, err := b.clientset.CoreV1().Pods(b.request.Namespace).GetLogs(pod, options).Stream(ctx)
+	if err != nil {
+		fw, zipErr := zw.Create(name + ".error.txt")
+		if zipErr != nil {
+			return zipErr
+		}
+		_, zipErr = fmt.Fprintf(fw, "Could not get logs: %s\n", err.Error())
+		return zipErr
+	}
+	defer readCloser.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name + ".log",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, readCloser)
+	return err
+}
+
+// restartCount returns the restart count of a container in a Pod.
+func restartCount(pod corev1.Pod, container string) int32 {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name == container {
+			return status.RestartCount
+		}
+	}
+
+	return 0
+}
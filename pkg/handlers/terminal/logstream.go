@@ -0,0 +1,380 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LogFormatText is the default format for log events, where the data of each event is a single log line.
+	LogFormatText = "text"
+	// LogFormatJSON is the format for log events, where the data of each event is a JSON encoded LogLine.
+	LogFormatJSON = "json"
+)
+
+// LogOptions are the options for the events of a log session. The include and exclude expressions are used to filter
+// the log lines on the server. When JSON parsing is enabled, the fields of JSON log lines are added to the events. If
+// no fields are specified all fields are added, otherwise only the specified fields. Nested fields can be specified
+// via dots, e.g. "error.message". The since time is used to stream the logs, which were written after this time.
+type LogOptions struct {
+	Format     string
+	Include    *regexp.Regexp
+	Exclude    *regexp.Regexp
+	ParseJSON  bool
+	JSONFields []string
+	SinceTime  *time.Time
+}
+
+// NewLogOptions returns the log options for the request.
+func NewLogOptions(request LogsRequest) (LogOptions, error) {
+	options := LogOptions{
+		Format:     request.Format,
+		ParseJSON:  request.ParseJSON || len(request.JSONFields) > 0,
+		JSONFields: request.JSONFields,
+	}
+
+	switch options.Format {
+	case "":
+		options.Format = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return options, fmt.Errorf("invalid format %s", request.Format)
+	}
+
+	// The fields of JSON log lines and the Pod and container of a line for sessions with multiple Pods can only be
+	// returned in the JSON format.
+	if options.ParseJSON || request.IsMulti() {
+		options.Format = LogFormatJSON
+	}
+
+	var err error
+	if request.Include != "" {
+		if options.Include, err = regexp.Compile(request.Include); err != nil {
+			return options, err
+		}
+	}
+
+	if request.Exclude != "" {
+		if options.Exclude, err = regexp.Compile(request.Exclude); err != nil {
+			return options, err
+		}
+	}
+
+	if request.SinceTime != "" {
+		sinceTime, err := time.Parse(time.RFC3339Nano, request.SinceTime)
+		if err != nil {
+			return options, err
+		}
+		options.SinceTime = &sinceTime
+	}
+
+	return options, nil
+}
+
+// logCursor is the position of a log line, which is used as id for the events. Because multiple lines can have the
+// same timestamp, the cursor contains the number of previous lines with the same timestamp. For sessions with multiple
+// Pods the lines of different containers are not strictly ordered, so that the cursor contains the position of the last
+// line for each container, with the "<pod>/<container>" as key.
+type logCursor struct {
+	timestamp  time.Time
+	seq        int
+	containers map[string]logCursor
+}
+
+// String returns the id of the event for the cursor. The id of a cursor for multiple containers has the format
+// "<pod>/<container>=<timestamp>_<seq>,...", the entries are sorted by their key.
+func (c logCursor) String() string {
+	if c.containers == nil {
+		return fmt.Sprintf("%s_%d", c.timestamp.UTC().Format(time.RFC3339Nano), c.seq)
+	}
+
+	keys := make([]string, 0, len(c.containers))
+	for key := range c.containers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, key+"="+c.containers[key].String())
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// container returns the position of the last line of the container with the given key. It returns nil, when the cursor
+// doesn't contain a position for the container.
+func (c *logCursor) container(key string) *logCursor {
+	if c == nil {
+		return nil
+	}
+
+	position, ok := c.containers[key]
+	if !ok {
+		return nil
+	}
+
+	return &position
+}
+
+// parseLogCursor parses the id of an event, e.g. from the "Last-Event-ID" header.
+func parseLogCursor(id string) (*logCursor, error) {
+	if strings.Contains(id, "=") {
+		return parseMultiLogCursor(id)
+	}
+
+	parts := strings.Split(id, "_")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid event id %s", id)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &logCursor{timestamp: timestamp, seq: seq}, nil
+}
+
+// parseMultiLogCursor parses the id of an event for a session with multiple containers.
+func parseMultiLogCursor(id string) (*logCursor, error) {
+	cursor := &logCursor{containers: make(map[string]logCursor)}
+
+	for _, entry := range strings.Split(id, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[1], "=") {
+			return nil, fmt.Errorf("invalid event id %s", id)
+		}
+
+		position, err := parseLogCursor(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		cursor.containers[parts[0]] = *position
+	}
+
+	return cursor, nil
+}
+
+// resumeSinceTime returns the time from which the logs must be streamed. When the client resumes the session, the logs
+// are streamed from the timestamp of the last event. For multiple containers the earliest timestamp of all containers
+// is used. The Kubernetes API only supports seconds for the since time, so that the events before the last event are
+// skipped by the logEventWriter.
+func resumeSinceTime(options LogOptions, resume *logCursor) *time.Time {
+	if resume == nil {
+		return options.SinceTime
+	}
+
+	timestamp := resume.timestamp
+	if resume.containers != nil {
+		timestamp = time.Time{}
+		for _, position := range resume.containers {
+			if timestamp.IsZero() || position.timestamp.Before(timestamp) {
+				timestamp = position.timestamp
+			}
+		}
+	}
+
+	sinceTime := timestamp.Truncate(time.Second)
+	return &sinceTime
+}
+
+// withLogTimestamps returns the request URI for a single log stream, with the "timestamps" parameter, which is required
+// for the event ids. When a since time is given, the "sinceTime" parameter replaces the "tailLines" and
+// "sinceSeconds" parameters.
+func withLogTimestamps(requestURI string, sinceTime *time.Time) (string, error) {
+	u, err := url.Parse(requestURI)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("timestamps", "true")
+
+	if sinceTime != nil {
+		query.Del("tailLines")
+		query.Del("sinceSeconds")
+		query.Set("sinceTime", sinceTime.UTC().Format(time.RFC3339))
+	}
+
+	u.RawQuery = query.Encode()
+	return u.RequestURI(), nil
+}
+
+// parseLogLine parses a raw log line with a timestamp prefix, which is added by the Kubernetes API when the
+// "timestamps" parameter is set.
+func parseLogLine(pod, container, raw string) (LogLine, time.Time) {
+	line := LogLine{Pod: pod, Container: container, Line: raw}
+
+	parts := strings.SplitN(raw, " ", 2)
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return line, time.Time{}
+	}
+
+	line.Timestamp = parts[0]
+	line.Line = ""
+	if len(parts) == 2 {
+		line.Line = parts[1]
+	}
+
+	return line, timestamp
+}
+
+// logEventWriter writes log lines as Server-Sent Events. It filters the lines, adds the fields of JSON log lines and
+// skips all lines up to the resume cursor. For sessions with multiple Pods the position of each container is tracked
+// separately, so that the lines of a container are only compared with the resume position of the same container.
+type logEventWriter struct {
+	w         http.ResponseWriter
+	options   LogOptions
+	stats     *sessionStats
+	resume    *logCursor
+	last      logCursor
+	multi     bool
+	positions map[string]logCursor
+}
+
+// newLogEventWriter returns a new writer for log events. When the writer is used for multiple containers, the id of the
+// events starts with the positions of the resume cursor, so that containers without new lines are kept in the id.
+func newLogEventWriter(w http.ResponseWriter, options LogOptions, stats *sessionStats, resume *logCursor, multi bool) *logEventWriter {
+	lw := &logEventWriter{
+		w:       w,
+		options: options,
+		stats:   stats,
+		resume:  resume,
+		multi:   multi,
+	}
+
+	if multi {
+		lw.positions = make(map[string]logCursor)
+		lw.last.containers = make(map[string]logCursor)
+		if resume != nil {
+			for key, position := range resume.containers {
+				lw.last.containers[key] = position
+			}
+		}
+	}
+
+	return lw
+}
+
+// write writes a single raw log line as event.
+func (lw *logEventWriter) write(pod, container, raw string) error {
+	line, timestamp := parseLogLine(pod, container, raw)
+
+	// The sequence number is counted for all lines, before the lines are filtered, so that the cursor doesn't depend
+	// on the filter.
+	if !timestamp.IsZero() {
+		key := pod + "/" + container
+		position := lw.last
+		resume := lw.resume
+		if lw.multi {
+			position = lw.positions[key]
+			resume = resume.container(key)
+		}
+
+		if timestamp.Equal(position.timestamp) {
+			position.seq++
+		} else {
+			position = logCursor{timestamp: timestamp}
+		}
+
+		if lw.multi {
+			lw.positions[key] = position
+		} else {
+			lw.last = position
+		}
+
+		if resume != nil && (timestamp.Before(resume.timestamp) || (timestamp.Equal(resume.timestamp) && position.seq <= resume.seq)) {
+			return nil
+		}
+
+		if lw.multi {
+			lw.last.containers[key] = position
+		}
+	}
+
+	if lw.options.Include != nil && !lw.options.Include.MatchString(line.Line) {
+		return nil
+	}
+
+	if lw.options.Exclude != nil && lw.options.Exclude.MatchString(line.Line) {
+		return nil
+	}
+
+	if lw.options.ParseJSON {
+		line.Fields = extractJSONFields(line.Line, lw.options.JSONFields)
+	}
+
+	data := line.Line
+	if lw.options.Format == LogFormatJSON {
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		data = string(encoded)
+	}
+
+	var event string
+	if timestamp.IsZero() {
+		event = fmt.Sprintf("data: %s\n\n", data)
+	} else {
+		event = fmt.Sprintf("id: %s\ndata: %s\n\n", lw.last.String(), data)
+	}
+
+	if _, err := lw.w.Write([]byte(event)); err != nil {
+		return err
+	}
+	lw.w.(http.Flusher).Flush()
+
+	lw.stats.addOut(len(event))
+	return nil
+}
+
+// extractJSONFields returns the fields of a JSON log line. It returns nil, when the line isn't a JSON object.
+func extractJSONFields(line string, fields []string) map[string]interface{} {
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return nil
+	}
+
+	if len(fields) == 0 {
+		return object
+	}
+
+	extracted := make(map[string]interface{})
+	for _, field := range fields {
+		var value interface{} = object
+		for _, key := range strings.Split(field, ".") {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = m[key]
+		}
+
+		if value != nil {
+			extracted[field] = value
+		}
+	}
+
+	return extracted
+}
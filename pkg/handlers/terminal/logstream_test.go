@@ -0,0 +1,196 @@
+package terminal
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseLogCursor(t *testing.T) {
+	timestamp := time.Date(2021, 9, 1, 12, 30, 15, 123456789, time.UTC)
+
+	for _, tc := range []struct {
+		name        string
+		id          string
+		expected    *logCursor
+		expectError bool
+	}{
+		{
+			name:     "single container",
+			id:       "2021-09-01T12:30:15.123456789Z_2",
+			expected: &logCursor{timestamp: timestamp, seq: 2},
+		},
+		{
+			name: "multiple containers",
+			id:   "nginx-1/nginx=2021-09-01T12:30:15.123456789Z_0,nginx-2/sidecar=2021-09-01T12:30:16Z_3",
+			expected: &logCursor{containers: map[string]logCursor{
+				"nginx-1/nginx":   {timestamp: timestamp, seq: 0},
+				"nginx-2/sidecar": {timestamp: timestamp.Truncate(time.Second).Add(time.Second), seq: 3},
+			}},
+		},
+		{name: "missing sequence", id: "2021-09-01T12:30:15Z", expectError: true},
+		{name: "invalid timestamp", id: "yesterday_1", expectError: true},
+		{name: "invalid sequence", id: "2021-09-01T12:30:15Z_first", expectError: true},
+		{name: "empty container key", id: "=2021-09-01T12:30:15Z_0", expectError: true},
+		{name: "invalid container position", id: "nginx-1/nginx=2021-09-01T12:30:15Z", expectError: true},
+		{name: "multiple equal signs", id: "nginx-1/nginx=a=2021-09-01T12:30:15Z_0", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseLogCursor(tc.id)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if !actual.timestamp.Equal(tc.expected.timestamp) || actual.seq != tc.expected.seq || len(actual.containers) != len(tc.expected.containers) {
+				t.Fatalf("expected cursor %v, got %v", tc.expected, actual)
+			}
+			for key, position := range tc.expected.containers {
+				if !actual.containers[key].timestamp.Equal(position.timestamp) || actual.containers[key].seq != position.seq {
+					t.Errorf("expected position %v for %s, got %v", position, key, actual.containers[key])
+				}
+			}
+
+			if actual.String() != tc.id {
+				t.Errorf("expected id %s, got %s", tc.id, actual.String())
+			}
+		})
+	}
+}
+
+func TestResumeSinceTime(t *testing.T) {
+	first := time.Date(2021, 9, 1, 12, 30, 15, 500, time.UTC)
+	second := time.Date(2021, 9, 1, 12, 31, 0, 0, time.UTC)
+	sinceTime := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		resume   *logCursor
+		expected *time.Time
+	}{
+		{name: "no cursor", resume: nil, expected: &sinceTime},
+		{name: "single container", resume: &logCursor{timestamp: first, seq: 1}, expected: timePtr(first.Truncate(time.Second))},
+		{name: "multiple containers", resume: &logCursor{containers: map[string]logCursor{"a/a": {timestamp: second}, "b/b": {timestamp: first}}}, expected: timePtr(first.Truncate(time.Second))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := resumeSinceTime(LogOptions{SinceTime: &sinceTime}, tc.resume)
+			if actual == nil || !actual.Equal(*tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestScanLogLines(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "short lines", input: "first\nsecond\r\nthird", expected: []string{"first", "second", "third"}},
+		{
+			name:     "long ascii line",
+			input:    strings.Repeat("a", maxLogLineSize+10) + "\n",
+			expected: []string{strings.Repeat("a", maxLogLineSize), strings.Repeat("a", 10)},
+		},
+		{
+			name:     "long line with multibyte character at the limit",
+			input:    strings.Repeat("a", maxLogLineSize-1) + "ä" + "b\n",
+			expected: []string{strings.Repeat("a", maxLogLineSize-1), "äb"},
+		},
+		{
+			name:     "long line with four byte character at the limit",
+			input:    strings.Repeat("a", maxLogLineSize-2) + "😀" + "b\n",
+			expected: []string{strings.Repeat("a", maxLogLineSize-2), "😀b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(bytes.NewBufferString(tc.input))
+			scanner.Buffer(make([]byte, 4096), maxLogLineSize)
+			scanner.Split(scanLogLines)
+
+			var lines []string
+			for scanner.Scan() {
+				if !utf8.Valid(scanner.Bytes()) {
+					t.Errorf("line %d is not valid UTF-8", len(lines))
+				}
+				lines = append(lines, scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(lines) != len(tc.expected) {
+				t.Fatalf("expected %d lines, got %d", len(tc.expected), len(lines))
+			}
+			for i := range lines {
+				if lines[i] != tc.expected[i] {
+					t.Errorf("line %d: expected %d bytes, got %d bytes", i, len(tc.expected[i]), len(lines[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestLogEventWriterResume(t *testing.T) {
+	lines := []struct{ pod, container, raw string }{
+		{"a", "app", "2021-09-01T12:00:01Z a1"},
+		{"b", "app", "2021-09-01T12:00:00Z b1"},
+		{"a", "app", "2021-09-01T12:00:01Z a2"},
+		{"b", "app", "2021-09-01T12:00:02Z b2"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		resume   string
+		expected []string
+	}{
+		{name: "no cursor", resume: "", expected: []string{"a1", "b1", "a2", "b2"}},
+		{name: "resume each container", resume: "a/app=2021-09-01T12:00:01Z_0,b/app=2021-09-01T12:00:00Z_0", expected: []string{"a2", "b2"}},
+		{name: "unknown container is streamed", resume: "a/app=2021-09-01T12:00:01Z_1", expected: []string{"b1", "b2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var resume *logCursor
+			if tc.resume != "" {
+				var err error
+				if resume, err = parseLogCursor(tc.resume); err != nil {
+					t.Fatalf("could not parse cursor: %v", err)
+				}
+			}
+
+			recorder := httptest.NewRecorder()
+			writer := newLogEventWriter(recorder, LogOptions{Format: LogFormatText}, nil, resume, true)
+			for _, line := range lines {
+				if err := writer.write(line.pod, line.container, line.raw); err != nil {
+					t.Fatalf("could not write line: %v", err)
+				}
+			}
+
+			var actual []string
+			for _, event := range strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n") {
+				for _, field := range strings.Split(event, "\n") {
+					if strings.HasPrefix(field, "data: ") {
+						actual = append(actual, strings.TrimPrefix(field, "data: "))
+					}
+				}
+			}
+
+			if strings.Join(actual, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected lines %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
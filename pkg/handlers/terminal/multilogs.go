@@ -0,0 +1,413 @@
+package terminal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxLogStreams is the maximum number of containers, which are tailed concurrently in a single log session.
+	maxLogStreams = 100
+	// maxLogLineSize is the maximum size of a single log line. Longer lines are split.
+	maxLogLineSize = 64 * 1024
+	// logRewatchDelay is the time to wait before the Pods are listed again, when the watch for the Pods was closed.
+	logRewatchDelay = 1 * time.Second
+)
+
+// LogsRequest is the structure of a request to stream logs. When the selector or the workload is set, the logs of all
+// containers in all matching Pods are streamed. Otherwise only the logs for the URL of the embedded request are
+// streamed. The container field is a regular expression to filter the containers by their name. The tail lines are
+// applied to all containers, which are running when the session starts. All other fields are used for the LogOptions
+// of the session.
+type LogsRequest struct {
+	kube.Request
+	Namespace    string   `json:"namespace"`
+	Selector     string   `json:"selector"`
+	WorkloadKind string   `json:"workloadKind"`
+	WorkloadName string   `json:"workloadName"`
+	Container    string   `json:"container"`
+	TailLines    int64    `json:"tailLines"`
+	Format       string   `json:"format"`
+	Include      string   `json:"include"`
+	Exclude      string   `json:"exclude"`
+	ParseJSON    bool     `json:"parseJSON"`
+	JSONFields   []string `json:"jsonFields"`
+	SinceTime    string   `json:"sinceTime"`
+}
+
+// IsMulti returns true, when the request should stream the logs of multiple Pods.
+func (r LogsRequest) IsMulti() bool {
+	return r.Selector != "" || r.WorkloadName != ""
+}
+
+// LogSelector selects the Pods and containers for a log session with multiple Pods.
+type LogSelector struct {
+	Namespace     string
+	LabelSelector string
+	Container     *regexp.Regexp
+	TailLines     int64
+}
+
+// LogLine is a single line of a log session in the JSON format. For log sessions with multiple Pods each line is tagged
+// with the Pod and container. The fields are only set, when JSON parsing is enabled and the line is a JSON object.
+type LogLine struct {
+	Pod       string                 `json:"pod,omitempty"`
+	Container string                 `json:"container,omitempty"`
+	Timestamp string                 `json:"timestamp,omitempty"`
+	Line      string                 `json:"line"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// NewLogSelector returns the selector for the request. When the request contains a workload, the label selector of
+// the workload is used.
+func NewLogSelector(ctx context.Context, clientset *kubernetes.Clientset, request LogsRequest) (*LogSelector, error) {
+	if request.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+
+	selector := &LogSelector{
+		Namespace:     request.Namespace,
+		LabelSelector: request.Selector,
+		TailLines:     request.TailLines,
+	}
+
+	if request.WorkloadName != "" {
+		labelSelector, err := workloadSelector(ctx, clientset, request.Namespace, request.WorkloadKind, request.WorkloadName)
+		if err != nil {
+			return nil, err
+		}
+		selector.LabelSelector = labelSelector
+	}
+
+	if request.Container != "" {
+		container, err := regexp.Compile(request.Container)
+		if err != nil {
+			return nil, err
+		}
+		selector.Container = container
+	}
+
+	return selector, nil
+}
+
+// workloadSelector returns the label selector of the Pods for a workload.
+func workloadSelector(ctx context.Context, clientset *kubernetes.Clientset, namespace, kind, name string) (string, error) {
+	var selector *metav1.LabelSelector
+
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments":
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = deployment.Spec.Selector
+	case "statefulset", "statefulsets":
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = statefulSet.Spec.Selector
+	case "daemonset", "daemonsets":
+		daemonSet, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = daemonSet.Spec.Selector
+	case "replicaset", "replicasets":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = replicaSet.Spec.Selector
+	case "job", "jobs":
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = job.Spec.Selector
+	case "service", "services":
+		service, err := clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if len(service.Spec.Selector) == 0 {
+			return "", fmt.Errorf("service %s has no selector", name)
+		}
+		selector = &metav1.LabelSelector{MatchLabels: service.Spec.Selector}
+	default:
+		return "", fmt.Errorf("unsupported workload kind %s", kind)
+	}
+
+	if selector == nil {
+		return "", fmt.Errorf("%s %s has no selector", kind, name)
+	}
+
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", err
+	}
+
+	return labelSelector.String(), nil
+}
+
+// multiLogStream tails the logs of all containers in all Pods, which are matching the selector. New Pods and restarted
+// containers are tailed as soon as they are running.
+type multiLogStream struct {
+	clientset *kubernetes.Clientset
+	selector  *LogSelector
+	sinceTime *time.Time
+	lines     chan LogLine
+	streams   map[string]context.CancelFunc
+	tailed    map[string]bool
+	retries   map[string]time.Time
+	lock      sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// streamMultiLogs starts to tail the logs of all matching containers. The lines contain the timestamp prefix of the
+// Kubernetes API. When the since time is set, it is used instead of the tail lines for the initial Pods. The returned
+// channel is closed, when the context is canceled and all log streams are closed.
+func streamMultiLogs(ctx context.Context, clientset *kubernetes.Clientset, selector *LogSelector, sinceTime *time.Time) <-chan LogLine {
+	s := &multiLogStream{
+		clientset: clientset,
+		selector:  selector,
+		sinceTime: sinceTime,
+		lines:     make(chan LogLine, 100),
+		streams:   make(map[string]context.CancelFunc),
+		tailed:    make(map[string]bool),
+		retries:   make(map[string]time.Time),
+	}
+
+	go func() {
+		s.watch(ctx)
+		s.wg.Wait()
+		close(s.lines)
+	}()
+
+	return s.lines
+}
+
+// watch lists and watches the Pods, until the context is canceled. When the watch is closed by the Kubernetes API, the
+// Pods are listed again.
+func (s *multiLogStream) watch(ctx context.Context) {
+	initial := true
+
+	for {
+		pods, err := s.clientset.CoreV1().Pods(s.selector.Namespace).List(ctx, metav1.ListOptions{LabelSelector: s.selector.LabelSelector})
+		if err != nil {
+			log.WithError(err).Errorf("Could not list pods for log session")
+		} else {
+			for i := range pods.Items {
+				s.handlePod(ctx, &pods.Items[i], initial)
+			}
+			initial = false
+
+			s.watchPods(ctx, pods.ResourceVersion)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logRewatchDelay):
+		}
+	}
+}
+
+// watchPods handles all changes of the matching Pods, until the watch is closed.
+func (s *multiLogStream) watchPods(ctx context.Context, resourceVersion string) {
+	watcher, err := s.clientset.CoreV1().Pods(s.selector.Namespace).Watch(ctx, metav1.ListOptions{
+		LabelSelector:   s.selector.LabelSelector,
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not watch pods for log session")
+		return
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				s.handlePod(ctx, pod, false)
+			case watch.Deleted:
+				s.stopPod(string(pod.UID))
+			}
+		}
+	}
+}
+
+// handlePod starts to tail all running containers of the Pod, which are not tailed yet. Each container instance is
+// identified by the uid of the Pod and its restart count, so that a restarted container is tailed again. The tail lines of the selector are
+// only used for the initial Pods, the logs of new containers are streamed from the beginning.
+func (s *multiLogStream) handlePod(ctx context.Context, pod *corev1.Pod, initial bool) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+
+	for _, status := range statuses {
+		if status.State.Running == nil {
+			continue
+		}
+
+		if s.selector.Container != nil && !s.selector.Container.MatchString(status.Name) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%d", pod.UID, status.Name, status.RestartCount)
+
+		s.lock.Lock()
+		if s.tailed[key] {
+			s.lock.Unlock()
+			continue
+		}
+
+		if len(s.streams) >= maxLogStreams {
+			s.lock.Unlock()
+			log.WithFields(log.Fields{"pod": pod.Name, "container": status.Name}).Warnf("Maximum number of log streams reached")
+			continue
+		}
+
+		streamCtx, cancel := context.WithCancel(ctx)
+		s.tailed[key] = true
+		s.streams[key] = cancel
+		s.wg.Add(1)
+		retry, isRetry := s.retries[key]
+		delete(s.retries, key)
+		s.lock.Unlock()
+
+		options := &corev1.PodLogOptions{
+			Container:  status.Name,
+			Follow:     true,
+			Timestamps: true,
+		}
+		if isRetry {
+			options.SinceTime = &metav1.Time{Time: retry}
+		} else if initial && s.sinceTime != nil {
+			options.SinceTime = &metav1.Time{Time: *s.sinceTime}
+		} else if initial && s.selector.TailLines > 0 {
+			options.TailLines = &s.selector.TailLines
+		}
+
+		go s.tail(streamCtx, key, pod.Name, options)
+	}
+}
+
+// stopPod stops all log streams for the Pod with the given uid.
+func (s *multiLogStream) stopPod(uid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, cancel := range s.streams {
+		if strings.HasPrefix(key, uid+"/") {
+			cancel()
+		}
+	}
+
+	for key := range s.tailed {
+		if strings.HasPrefix(key, uid+"/") {
+			delete(s.tailed, key)
+		}
+	}
+
+	for key := range s.retries {
+		if strings.HasPrefix(key, uid+"/") {
+			delete(s.retries, key)
+		}
+	}
+}
+
+// tail streams the logs of a single container and sends each line to the lines channel. When the stream can not be
+// opened or ends with an error, the container is removed from the tailed containers, so that it is tailed again with
+// the next update of the Pod. The retried stream starts at the timestamp of the last received line.
+func (s *multiLogStream) tail(ctx context.Context, key, pod string, options *corev1.PodLogOptions) {
+	var err error
+	var last time.Time
+
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		if cancel, ok := s.streams[key]; ok {
+			cancel()
+			delete(s.streams, key)
+		}
+		if err != nil && ctx.Err() == nil {
+			delete(s.tailed, key)
+			if !last.IsZero() {
+				s.retries[key] = last
+			}
+		}
+		s.lock.Unlock()
+	}()
+
+	readCloser, err := s.clientset.CoreV1().Pods(s.selector.Namespace).GetLogs(pod, options).Stream(ctx)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"pod": pod, "container": options.Container}).Errorf("Could not stream logs")
+		return
+	}
+	defer readCloser.Close()
+
+	scanner := bufio.NewScanner(readCloser)
+	scanner.Buffer(make([]byte, 4096), maxLogLineSize)
+	scanner.Split(scanLogLines)
+
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return
+		case s.lines <- LogLine{Pod: pod, Container: options.Container, Line: scanner.Text()}:
+		}
+
+		if _, timestamp := parseLogLine(pod, options.Container, scanner.Text()); !timestamp.IsZero() {
+			last = timestamp
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		log.WithError(err).WithFields(log.Fields{"pod": pod, "container": options.Container}).Errorf("Log stream failed")
+	}
+}
+
+// scanLogLines is a split function for a scanner, which splits the logs into lines. In contrast to bufio.ScanLines,
+// lines which are longer than the buffer of the scanner are split instead of returning an error. A long line is split
+// before the last incomplete UTF-8 character, so that a character is never split into two lines.
+func scanLogLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if err == nil && token == nil && !atEOF && len(data) >= maxLogLineSize {
+		cut := len(data)
+		for i := len(data) - 1; i > 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		return cut, data[:cut], nil
+	}
+	return advance, token, err
+}
@@ -0,0 +1,334 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// recordingExtension is the file extension for all recordings.
+	recordingExtension = ".cast"
+	// maxReplayDelay is the maximum delay between two events during the replay of a recording, so that the user hasn't
+	// to wait when the recorded session was idle for a long time.
+	maxReplayDelay = 2 * time.Second
+)
+
+var (
+	recordingsDir    string
+	validRecordingID = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+)
+
+// SetRecordingsDir sets the directory, where the recordings of all exec and SSH sessions are saved. When the directory
+// is empty, terminal sessions are not recorded.
+func SetRecordingsDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	recordingsDir = dir
+	return nil
+}
+
+// RecordingHeader is the header of a recording in the asciicast v2 format. See
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md for the specification of the format. The
+// owner is not part of the specification and is ignored by other players. It is the identity of the client, which
+// created the recorded session.
+type RecordingHeader struct {
+	Version   int    `json:"version"`
+	Width     uint16 `json:"width"`
+	Height    uint16 `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// Recording is the structure of a recording, which is returned by ListRecordings.
+type Recording struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Timestamp int64  `json:"timestamp"`
+	Width     uint16 `json:"width"`
+	Height    uint16 `json:"height"`
+	Size      int64  `json:"size"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// Recorder writes the output and the resize events of a terminal session to a file in the asciicast v2 format.
+type Recorder struct {
+	file  *os.File
+	start time.Time
+	lock  sync.Mutex
+}
+
+// NewRecorder creates a new recording for the session with the given id. The owner is the owner of the session, only
+// this client and admins can access the recording. It returns nil, when recording is disabled.
+func NewRecorder(sessionID, owner, title string) (*Recorder, error) {
+	if recordingsDir == "" {
+		return nil, nil
+	}
+
+	start := time.Now()
+	name := filepath.Join(recordingsDir, fmt.Sprintf("%s-%s%s", start.UTC().Format("20060102T150405Z"), sessionID, recordingExtension))
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(RecordingHeader{
+		Version:   2,
+		Width:     80,
+		Height:    40,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Owner:     owner,
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Recorder{file: file, start: start}, nil
+}
+
+// Output records the output of the process.
+func (r *Recorder) Output(p []byte) {
+	r.write("o", string(p))
+}
+
+// Resize records a resize event of the terminal.
+func (r *Recorder) Resize(cols, rows uint16) {
+	r.write("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close closes the file of the recording.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// write writes a single event to the recording.
+func (r *Recorder) write(eventType, data string) {
+	event, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), eventType, data})
+	if err != nil {
+		log.WithError(err).Errorf("Could not marshal recording event")
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, err := r.file.Write(append(event, '\n')); err != nil {
+		log.WithError(err).Errorf("Could not write recording event")
+	}
+}
+
+// ListRecordings returns all recordings sorted by their timestamp, starting with the newest recording. The recordings
+// are not filtered by their owner, so that the caller must check if the user can access a recording.
+func ListRecordings() ([]Recording, error) {
+	if recordingsDir == "" {
+		return nil, fmt.Errorf("recording is disabled")
+	}
+
+	files, err := ioutil.ReadDir(recordingsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var recordings []Recording
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), recordingExtension) {
+			continue
+		}
+
+		id := strings.TrimSuffix(file.Name(), recordingExtension)
+		header, err := ReadRecordingHeader(id)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"recording": id}).Debugf("Could not read recording header")
+			continue
+		}
+
+		recordings = append(recordings, Recording{
+			ID:        id,
+			Title:     header.Title,
+			Timestamp: header.Timestamp,
+			Width:     header.Width,
+			Height:    header.Height,
+			Size:      file.Size(),
+			Owner:     header.Owner,
+		})
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Timestamp > recordings[j].Timestamp
+	})
+
+	return recordings, nil
+}
+
+// OpenRecording opens the recording with the given id. The caller must close the returned file.
+func OpenRecording(id string) (*os.File, error) {
+	if recordingsDir == "" {
+		return nil, fmt.Errorf("recording is disabled")
+	}
+
+	if !validRecordingID.MatchString(id) {
+		return nil, fmt.Errorf("invalid recording id")
+	}
+
+	return os.Open(filepath.Join(recordingsDir, id+recordingExtension))
+}
+
+// ReadRecordingHeader returns the header of the recording with the given id.
+func ReadRecordingHeader(id string) (*RecordingHeader, error) {
+	file, err := OpenRecording(id)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var header RecordingHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// handleReplaySession is called by net/http for any new /api/kubernetes/recordings/sockjs and
+// /api/kubernetes/recordings/ws connections. The client must send a "bind" message with the id of the recording as
+// session id. Then the recording is streamed back to the client via the same messages as a live terminal session, when
+// the client is allowed to access the recording.
+func handleReplaySession(conn Conn) {
+	msg, err := conn.Recv()
+	if err != nil {
+		log.WithError(err).Errorf("handleReplaySession: can't Recv")
+		conn.Close(2, "Invalid message")
+		return
+	}
+
+	if msg.Op != "bind" {
+		log.WithFields(log.Fields{"op": msg.Op}).Errorf("handleReplaySession: expected 'bind' message")
+		conn.Close(2, "Expected bind message")
+		return
+	}
+
+	header, err := ReadRecordingHeader(msg.SessionID)
+	if err != nil {
+		log.WithError(err).Errorf("handleReplaySession: can't read recording")
+		conn.Close(2, "Recording not found")
+		return
+	}
+
+	if !conn.Client().CanAccess(header.Owner) {
+		log.WithFields(log.Fields{"recording": msg.SessionID}).Errorf("handleReplaySession: recording is owned by another client")
+		conn.Close(2, "Recording not found")
+		return
+	}
+
+	if err := replay(conn, msg.SessionID); err != nil {
+		log.WithError(err).Errorf("Replay was closed")
+		conn.Close(2, err.Error())
+		return
+	}
+
+	conn.Close(1, "Replay finished")
+}
+
+// replay sends all events of a recording to the client. The delay between the events is the same as in the recording,
+// but limited to maxReplayDelay.
+func replay(conn Conn, id string) error {
+	file, err := OpenRecording(id)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	if !scanner.Scan() {
+		return fmt.Errorf("recording is empty")
+	}
+
+	var header RecordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return err
+	}
+
+	if err := conn.Send(TerminalMessage{Op: "resize", Cols: header.Width, Rows: header.Height}); err != nil {
+		return err
+	}
+
+	var last float64
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid recording event")
+		}
+
+		offset, _ := event[0].(float64)
+		eventType, _ := event[1].(string)
+		data, _ := event[2].(string)
+
+		delay := time.Duration((offset - last) * float64(time.Second))
+		if delay > maxReplayDelay {
+			delay = maxReplayDelay
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		last = offset
+
+		switch eventType {
+		case "o":
+			err = conn.Send(TerminalMessage{Op: "stdout", Data: data})
+		case "r":
+			var cols, rows uint16
+			if _, scanErr := fmt.Sscanf(data, "%dx%d", &cols, &rows); scanErr == nil {
+				err = conn.Send(TerminalMessage{Op: "resize", Cols: cols, Rows: rows})
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// CreateReplayHandler is called from main for /api/kubernetes/recordings/sockjs.
+func CreateReplayHandler(path string, client ClientFunc) http.Handler {
+	return newSockJSHandler(path, client, handleReplaySession)
+}
+
+// CreateWebSocketReplayHandler is called from main for /api/kubernetes/recordings/ws.
+func CreateWebSocketReplayHandler(client ClientFunc, checkOrigin OriginFunc) http.Handler {
+	return newWebSocketHandler(client, checkOrigin, handleReplaySession)
+}
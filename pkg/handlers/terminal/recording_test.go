@@ -0,0 +1,94 @@
+package terminal
+
+import (
+	"testing"
+)
+
+// replayConn is a connection for tests, which sends a single bind message and saves all received messages and the
+// close status.
+type replayConn struct {
+	client   Client
+	bind     string
+	messages []TerminalMessage
+	status   uint32
+}
+
+func (c *replayConn) Recv() (TerminalMessage, error) {
+	return TerminalMessage{Op: "bind", SessionID: c.bind}, nil
+}
+
+func (c *replayConn) Send(msg TerminalMessage) error {
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *replayConn) Close(status uint32, reason string) error {
+	c.status = status
+	return nil
+}
+
+func (c *replayConn) Client() Client { return c.client }
+
+func TestClientCanAccess(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		client   Client
+		owner    string
+		expected bool
+	}{
+		{name: "owner", client: Client{Identity: "user:alice"}, owner: "user:alice", expected: true},
+		{name: "other client", client: Client{Identity: "user:bob"}, owner: "user:alice", expected: false},
+		{name: "client without identity", client: Client{}, owner: "user:alice", expected: false},
+		{name: "admin", client: Client{Identity: "user:bob", Admin: true}, owner: "user:alice", expected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.client.CanAccess(tc.owner); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRecordingOwner(t *testing.T) {
+	if err := SetRecordingsDir(t.TempDir()); err != nil {
+		t.Fatalf("could not set recordings dir: %v", err)
+	}
+	defer SetRecordingsDir("")
+
+	recorder, err := NewRecorder("session", "user:alice", "title")
+	if err != nil {
+		t.Fatalf("could not create recorder: %v", err)
+	}
+	recorder.Output([]byte("hello"))
+	recorder.Close()
+
+	recordings, err := ListRecordings()
+	if err != nil {
+		t.Fatalf("could not list recordings: %v", err)
+	}
+	if len(recordings) != 1 || recordings[0].Owner != "user:alice" {
+		t.Fatalf("expected one recording of user:alice, got %v", recordings)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		client         Client
+		expectedStatus uint32
+	}{
+		{name: "owner", client: Client{Identity: "user:alice"}, expectedStatus: 1},
+		{name: "admin", client: Client{Identity: "user:bob", Admin: true}, expectedStatus: 1},
+		{name: "other client", client: Client{Identity: "user:bob"}, expectedStatus: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &replayConn{client: tc.client, bind: recordings[0].ID}
+			handleReplaySession(conn)
+
+			if conn.status != tc.expectedStatus {
+				t.Errorf("expected close status %d, got %d", tc.expectedStatus, conn.status)
+			}
+			if tc.expectedStatus != 1 && len(conn.messages) != 0 {
+				t.Errorf("expected no replayed messages, got %v", conn.messages)
+			}
+		})
+	}
+}
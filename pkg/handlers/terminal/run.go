@@ -0,0 +1,252 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/util/exec"
+)
+
+const (
+	// defaultRunTimeout is the timeout for a command, when the request doesn't contain a timeout.
+	defaultRunTimeout = 30 * time.Second
+	// maxRunTimeout is the maximum timeout for a command. Larger timeouts from a request are reduced to this value.
+	maxRunTimeout = 5 * time.Minute
+	// defaultRunLimit is the maximum number of bytes, which are captured for stdout and stderr, when the request doesn't
+	// contain a limit.
+	defaultRunLimit = 1024 * 1024
+	// maxRunLimit is the maximum limit for a command. Larger limits from a request are reduced to this value, so that a
+	// single request can not allocate an unbounded amount of memory.
+	maxRunLimit = 10 * 1024 * 1024
+)
+
+// RunRequest is the structure of a request to run a command in a container without a tty. The command is a list of
+// arguments, which is not executed in a shell. The stdin field is sent to the command as stdin. The limit is the
+// maximum number of bytes, which are captured for stdout and stderr. The timeout field of the embedded request is used
+// as timeout for the command in seconds. The limit and the timeout are capped at maxRunLimit and maxRunTimeout.
+type RunRequest struct {
+	kube.Request
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+	Stdin     string   `json:"stdin"`
+	Limit     int      `json:"limit"`
+}
+
+// RunResponse is the structure of the response for a command. It contains the captured stdout and stderr of the
+// command and the exit code. When the output exceeds the limit, the output is truncated. When the command was not
+// finished before the timeout, the exit code is -1.
+type RunResponse struct {
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool   `json:"stderrTruncated,omitempty"`
+	ExitCode        int    `json:"exitCode"`
+	TimedOut        bool   `json:"timedOut,omitempty"`
+}
+
+// LimitedBuffer is a buffer, which only saves the first bytes up to the limit. All other bytes are discarded, so that
+// the stream of the command isn't aborted, when the output exceeds the limit.
+type LimitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// NewLimitedBuffer returns a new buffer with the given limit.
+func NewLimitedBuffer(limit int) *LimitedBuffer {
+	return &LimitedBuffer{limit: limit}
+}
+
+// Write writes the bytes to the buffer, until the limit is reached.
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buffer.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+
+	return b.buffer.Write(p)
+}
+
+// String returns the content of the buffer.
+func (b *LimitedBuffer) String() string {
+	return b.buffer.String()
+}
+
+// Truncated returns true, when bytes were discarded because the limit was reached.
+func (b *LimitedBuffer) Truncated() bool {
+	return b.truncated
+}
+
+// cancelableUpgrader closes the upgraded connection, when the context is canceled. This is required to abort a stream
+// after the timeout, because the executor doesn't support a context.
+type cancelableUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
+
+// NewConnection creates a new connection from the upgraded response and closes it, when the context is canceled.
+func (u *cancelableUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+
+	return conn, nil
+}
+
+// NewExecutor returns a new executor for the given URL. In contrast to the executor returned by
+// remotecommand.NewSPDYExecutor, the stream of the returned executor is aborted, when the context is canceled.
+func NewExecutor(ctx context.Context, config *rest.Config, reqURL *url.URL) (remotecommand.Executor, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewSPDYExecutorForTransports(transport, &cancelableUpgrader{Upgrader: upgrader, ctx: ctx}, http.MethodPost, reqURL)
+}
+
+// ExecURL returns the URL to execute a command without a tty in a container.
+func ExecURL(clientset *kubernetes.Clientset, namespace, name, container string, command []string, stdin bool) *url.URL {
+	return clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin,
+			Stdout:    true,
+			Stderr:    true,
+			TTY:       false,
+		}, scheme.ParameterCodec).
+		URL()
+}
+
+// RunURL returns the URL to run the command from the request in a container.
+func RunURL(clientset *kubernetes.Clientset, request RunRequest) *url.URL {
+	return ExecURL(clientset, request.Namespace, request.Name, request.Container, request.Command, request.Stdin != "")
+}
+
+// runTimeout returns the timeout for the command from the request. When the request doesn't contain a timeout the
+// defaultRunTimeout is used, larger timeouts than the maxRunTimeout are reduced.
+func runTimeout(request RunRequest) time.Duration {
+	if request.Timeout <= 0 {
+		return defaultRunTimeout
+	}
+
+	if request.Timeout > int64(maxRunTimeout/time.Second) {
+		return maxRunTimeout
+	}
+
+	return time.Duration(request.Timeout) * time.Second
+}
+
+// runLimit returns the limit for the output of the command from the request. When the request doesn't contain a limit
+// the defaultRunLimit is used, larger limits than the maxRunLimit are reduced.
+func runLimit(request RunRequest) int {
+	if request.Limit <= 0 {
+		return defaultRunLimit
+	}
+
+	if request.Limit > maxRunLimit {
+		return maxRunLimit
+	}
+
+	return request.Limit
+}
+
+// Run runs the command from the request in a container and returns the captured output and the exit code. A non-zero
+// exit code is not returned as error.
+func Run(ctx context.Context, config *rest.Config, clientset *kubernetes.Clientset, request RunRequest) (*RunResponse, error) {
+	if request.Namespace == "" || request.Name == "" {
+		return nil, fmt.Errorf("namespace and name of the pod are required")
+	}
+
+	if len(request.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+
+	limit := runLimit(request)
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout(request))
+	defer cancel()
+
+	executor, err := NewExecutor(ctx, config, RunURL(clientset, request))
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := NewLimitedBuffer(limit)
+	stderr := NewLimitedBuffer(limit)
+
+	var stdin io.Reader
+	if request.Stdin != "" {
+		stdin = strings.NewReader(request.Stdin)
+	}
+
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    false,
+	})
+
+	response := &RunResponse{
+		Stdout:          stdout.String(),
+		Stderr:          stderr.String(),
+		StdoutTruncated: stdout.Truncated(),
+		StderrTruncated: stderr.Truncated(),
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		response.ExitCode = -1
+		response.TimedOut = true
+		return response, nil
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.Exited() {
+			response.ExitCode = exitErr.ExitStatus()
+			return response, nil
+		}
+
+		return nil, err
+	}
+
+	return response, nil
+}
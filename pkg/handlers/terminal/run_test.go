@@ -0,0 +1,48 @@
+package terminal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+)
+
+func TestRunTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		timeout  int64
+		expected time.Duration
+	}{
+		{name: "no timeout", timeout: 0, expected: defaultRunTimeout},
+		{name: "negative timeout", timeout: -1, expected: defaultRunTimeout},
+		{name: "timeout", timeout: 60, expected: 60 * time.Second},
+		{name: "max timeout", timeout: 300, expected: maxRunTimeout},
+		{name: "timeout above max", timeout: 3600, expected: maxRunTimeout},
+		{name: "overflowing timeout", timeout: 1 << 62, expected: maxRunTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := runTimeout(RunRequest{Request: kube.Request{Timeout: tc.timeout}}); actual != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limit    int
+		expected int
+	}{
+		{name: "no limit", limit: 0, expected: defaultRunLimit},
+		{name: "negative limit", limit: -1, expected: defaultRunLimit},
+		{name: "limit", limit: 1024, expected: 1024},
+		{name: "limit above max", limit: maxRunLimit + 1, expected: maxRunLimit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := runLimit(RunRequest{Limit: tc.limit}); actual != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, actual)
+			}
+		})
+	}
+}
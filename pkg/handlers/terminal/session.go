@@ -0,0 +1,230 @@
+package terminal
+
+import (
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// SessionConfig is the configuration for the lifetime of terminal and log sessions. A session which isn't bound by a
+// client within the bind timeout is removed. A terminal session without any input or output within the idle timeout
+// and all sessions which are older than the max lifetime are closed. A value of 0 disables the corresponding timeout.
+type SessionConfig struct {
+	BindTimeout time.Duration
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// DefaultSessionConfig is the session configuration, which is used when no other configuration is set. Only the bind
+// timeout is enabled by default, so that sessions which are never used are not kept forever.
+var DefaultSessionConfig = SessionConfig{
+	BindTimeout: 1 * time.Minute,
+}
+
+var sessionConfig = DefaultSessionConfig
+
+// SetSessionConfig sets the configuration for the lifetime of terminal and log sessions.
+func SetSessionConfig(config SessionConfig) {
+	sessionConfig = config
+}
+
+// GetSessionConfig returns the configuration for the lifetime of terminal and log sessions.
+func GetSessionConfig() SessionConfig {
+	return sessionConfig
+}
+
+// SessionInfo contains the information about a terminal or log session, which is returned by the session management
+// API. The namespace, pod and container are set for exec and log sessions, the address is set for SSH sessions and the
+// node is set for node shell sessions. For log sessions with multiple Pods the label selector of the Pods is set.
+type SessionInfo struct {
+	Type      string `json:"type"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Node      string `json:"node,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+}
+
+// NewSessionInfo returns the information for a session of the given type. The namespace, pod and container are parsed
+// from the request URL, e.g. "/api/v1/namespaces/default/pods/nginx/exec?container=nginx".
+func NewSessionInfo(sessionType, cluster, requestURL string) SessionInfo {
+	info := SessionInfo{
+		Type:    sessionType,
+		Cluster: cluster,
+	}
+
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return info
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		switch parts[i] {
+		case "namespaces":
+			info.Namespace = parts[i+1]
+		case "pods":
+			info.Pod = parts[i+1]
+		}
+	}
+
+	info.Container = u.Query().Get("container")
+	return info
+}
+
+// sessionStats contains the statistics of a session. The stats are shared between all copies of a session, so that
+// they can be updated from the Read and Write methods.
+type sessionStats struct {
+	lastActivity int64
+	bytesIn      int64
+	bytesOut     int64
+}
+
+// newSessionStats returns new statistics for a session, which was created now.
+func newSessionStats(now time.Time) *sessionStats {
+	return &sessionStats{lastActivity: now.UnixNano()}
+}
+
+// addIn adds the given number of bytes to the received bytes and updates the time of the last activity.
+func (s *sessionStats) addIn(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.bytesIn, int64(n))
+		atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	}
+}
+
+// addOut adds the given number of bytes to the sent bytes and updates the time of the last activity.
+func (s *sessionStats) addOut(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.bytesOut, int64(n))
+		atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	}
+}
+
+// NewTerminalSession returns a new terminal session with the given id. The owner is the identity of the client which
+// created the session, only this client can bind the session.
+func NewTerminalSession(sessionID, owner string, info SessionInfo) TerminalSession {
+	now := time.Now()
+
+	return TerminalSession{
+		ID:       sessionID,
+		Owner:    owner,
+		Info:     info,
+		Created:  now,
+		Bound:    make(chan TerminalSession),
+		SizeChan: make(chan remotecommand.TerminalSize),
+		DoneChan: make(chan struct{}),
+		stats:    newSessionStats(now),
+	}
+}
+
+// LastActivity returns the time of the last input or output of the session.
+func (t TerminalSession) LastActivity() time.Time {
+	if t.stats == nil {
+		return t.Created
+	}
+
+	return time.Unix(0, atomic.LoadInt64(&t.stats.lastActivity))
+}
+
+// BytesIn returns the number of bytes, which were received from the client.
+func (t TerminalSession) BytesIn() int64 {
+	if t.stats == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&t.stats.bytesIn)
+}
+
+// BytesOut returns the number of bytes, which were sent to the client.
+func (t TerminalSession) BytesOut() int64 {
+	if t.stats == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&t.stats.bytesOut)
+}
+
+// NewLogSession returns a new log session for the given URL. The owner is the identity of the client which created the
+// session, only this client can stream the logs.
+func NewLogSession(clientset *kubernetes.Clientset, requestURL, owner string, info SessionInfo) LogSession {
+	now := time.Now()
+
+	return LogSession{
+		ClientSet: clientset,
+		URL:       requestURL,
+		Owner:     owner,
+		Info:      info,
+		Created:   now,
+		done:      make(chan struct{}),
+		stats:     newSessionStats(now),
+	}
+}
+
+// BytesOut returns the number of bytes, which were sent to the client.
+func (s LogSession) BytesOut() int64 {
+	if s.stats == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&s.stats.bytesOut)
+}
+
+// Reap closes all terminal sessions, which were not bound within the bind timeout, which were idle for longer than the
+// idle timeout or which are older than the max lifetime.
+func (sm *SessionMap) Reap() {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	now := time.Now()
+
+	for sessionID, session := range sm.Sessions {
+		reason := ""
+
+		switch {
+		case session.Conn == nil && sessionConfig.BindTimeout > 0 && now.Sub(session.Created) > sessionConfig.BindTimeout:
+			reason = "Session was not bound in time"
+		case session.Conn != nil && sessionConfig.IdleTimeout > 0 && now.Sub(session.LastActivity()) > sessionConfig.IdleTimeout:
+			reason = "Session was idle for too long"
+		case sessionConfig.MaxLifetime > 0 && now.Sub(session.Created) > sessionConfig.MaxLifetime:
+			reason = "Session reached the maximum lifetime"
+		}
+
+		if reason != "" {
+			log.WithFields(log.Fields{"session": sessionID, "reason": reason}).Infof("Close terminal session")
+			sm.close(sessionID, 2, reason)
+		}
+	}
+}
+
+// Reap removes all log sessions, which were not bound within the bind timeout. Bound log sessions are closed by the
+// stream handler, when they reach the max lifetime.
+func (sm *LogSessionMap) Reap() {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	if sessionConfig.BindTimeout <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	for sessionID, session := range sm.Sessions {
+		unbound := session.Created
+		if session.Released.After(unbound) {
+			unbound = session.Released
+		}
+
+		if !session.Bound && now.Sub(unbound) > sessionConfig.BindTimeout {
+			log.WithFields(log.Fields{"session": sessionID}).Infof("Remove unbound log session")
+			delete(sm.Sessions, sessionID)
+		}
+	}
+}
@@ -1,53 +1,310 @@
 package terminal
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	"gopkg.in/igm/sockjs-go.v2/sockjs"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-// SSHRequest defines the structure to init a new SSH session.
+// sshDialTimeout is the timeout to establish the TCP connection and the SSH handshake for each host.
+const sshDialTimeout = 15 * time.Second
+
+// SSHHost defines the address, user, credentials and the accepted host keys of a single SSH host. The host key is
+// accepted when it matches one of the pinned fingerprints (SHA256 or legacy MD5 format) or when it is valid for the
+// known_hosts content. The key can be encrypted with the passphrase. Password authentication and keyboard-interactive
+// authentication are also supported. For keyboard-interactive authentication the answers are used in the order of the
+// questions, when no answer is given the password is used.
+type SSHHost struct {
+	Address      string   `json:"address"`
+	User         string   `json:"user"`
+	Key          string   `json:"key"`
+	Passphrase   string   `json:"passphrase"`
+	Password     string   `json:"password"`
+	Answers      []string `json:"answers"`
+	KnownHosts   string   `json:"knownHosts"`
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// SSHRequest defines the structure to init a new SSH session. The connection to the target host can be established
+// via a chain of jump hosts, which are used in the given order. When trust on first use is enabled, the fingerprint of
+// an unknown host key is returned to the user for confirmation, instead of establishing the session. When agent
+// forwarding is enabled, the keys of the target host and all jump hosts are forwarded to the target host.
 type SSHRequest struct {
-	Key     string `json:"key"`
-	Address string `json:"address"`
-	User    string `json:"user"`
+	SSHHost
+	JumpHosts       []SSHHost `json:"jumpHosts"`
+	TrustOnFirstUse bool      `json:"trustOnFirstUse"`
+	ForwardAgent    bool      `json:"forwardAgent"`
+}
+
+// SSHHostKeyResponse is returned to the user, when trust on first use is enabled and the host key of a host is unknown.
+// The user must confirm the fingerprint, by sending the request again with the fingerprint added to the pinned
+// fingerprints of the host. The known hosts field contains the host key in the known_hosts format, so that it can be
+// saved by the user.
+type SSHHostKeyResponse struct {
+	Address     string `json:"address"`
+	Fingerprint string `json:"fingerprint"`
+	KnownHosts  string `json:"knownHosts"`
+}
+
+// UnknownHostKeyError is returned by DialSSH, when trust on first use is enabled and the host key of a host is unknown.
+type UnknownHostKeyError struct {
+	Address string
+	Key     ssh.PublicKey
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("host key for %s is unknown: %s", e.Address, ssh.FingerprintSHA256(e.Key))
+}
+
+// Response returns the response for the user, which contains the fingerprint of the unknown host key.
+func (e *UnknownHostKeyError) Response() SSHHostKeyResponse {
+	return SSHHostKeyResponse{
+		Address:     e.Address,
+		Fingerprint: ssh.FingerprintSHA256(e.Key),
+		KnownHosts:  knownhosts.Line([]string{knownhosts.Normalize(e.Address)}, e.Key),
+	}
+}
+
+// SSHConnection is an established SSH connection to the target host. It contains the clients for all jump hosts, so
+// that they can be closed together with the connection to the target host.
+type SSHConnection struct {
+	client  *ssh.Client
+	clients []*ssh.Client
+	agent   agent.Agent
+}
+
+// Close closes the connection to the target host and all jump hosts, starting with the target host.
+func (c *SSHConnection) Close() error {
+	var err error
+	for i := len(c.clients) - 1; i >= 0; i-- {
+		if closeErr := c.clients[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // CreateSSHHandler is called from main for /api/kubernetes/exec/sockjs
-func CreateSSHHandler(path string) http.Handler {
-	return sockjs.NewHandler(path, sockjs.DefaultOptions, handleTerminalSession)
+func CreateSSHHandler(path string, client ClientFunc) http.Handler {
+	return newSockJSHandler(path, client, handleTerminalSession)
 }
 
-// startSSHProcess is called by sshHandler
-// Executed cmd in the container specified in request and connects it up with the ptyHandler (a session)
-func startSSHProcess(key, addr, user string, ptyHandler PtyHandler) error {
-	signer, err := ssh.ParsePrivateKey([]byte(key))
+// DialSSH establishes the connection to the target host of the request via all jump hosts. The connection is
+// established before the terminal session is created, so that authentication and host key errors can be returned to
+// the user. The caller must close the returned connection.
+func DialSSH(request SSHRequest) (*SSHConnection, error) {
+	conn := &SSHConnection{}
+	if request.ForwardAgent {
+		conn.agent = agent.NewKeyring()
+	}
+
+	// The ssh package doesn't wrap the errors returned by the host key callback, so that the callback saves the unknown
+	// host key and we return the UnknownHostKeyError instead of the error from the handshake.
+	var unknownHostKeyErr *UnknownHostKeyError
+
+	hosts := append(append([]SSHHost{}, request.JumpHosts...), request.SSHHost)
+	for _, host := range hosts {
+		config, err := sshClientConfig(host, request.TrustOnFirstUse, &unknownHostKeyErr, conn.agent)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		var client *ssh.Client
+		if conn.client == nil {
+			client, err = ssh.Dial("tcp", host.Address, config)
+		} else {
+			client, err = dialViaJumpHost(conn.client, host.Address, config)
+		}
+		if err != nil {
+			conn.Close()
+			if unknownHostKeyErr != nil {
+				return nil, unknownHostKeyErr
+			}
+			return nil, err
+		}
+
+		conn.client = client
+		conn.clients = append(conn.clients, client)
+	}
+
+	if conn.agent != nil {
+		if err := agent.ForwardToAgent(conn.client, conn.agent); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// dialViaJumpHost establishes a new SSH connection to the given address through the connection to a jump host.
+func dialViaJumpHost(jumpHost *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := jumpHost.Dial("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	netConn.SetDeadline(time.Now().Add(sshDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
 	}
+	netConn.SetDeadline(time.Time{})
 
-	client, err := ssh.Dial("tcp", addr, sshConfig)
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// sshClientConfig returns the client config for a host. When the keyring for the agent forwarding is not nil, the key
+// of the host is added to the keyring.
+func sshClientConfig(host SSHHost, trustOnFirstUse bool, unknownHostKeyErr **UnknownHostKeyError, keyring agent.Agent) (*ssh.ClientConfig, error) {
+	var authMethods []ssh.AuthMethod
+
+	if host.Key != "" {
+		var key interface{}
+		var err error
+
+		if host.Passphrase != "" {
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(host.Key), []byte(host.Passphrase))
+		} else {
+			key, err = ssh.ParseRawPrivateKey([]byte(host.Key))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse private key for %s: %w", host.Address, err)
+		}
+
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+
+		if keyring != nil {
+			if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if host.Password != "" {
+		authMethods = append(authMethods, ssh.Password(host.Password))
+	}
+
+	if host.Password != "" || len(host.Answers) > 0 {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				if i < len(host.Answers) {
+					answers[i] = host.Answers[i]
+				} else {
+					answers[i] = host.Password
+				}
+			}
+			return answers, nil
+		}))
+	}
+
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("no authentication method for %s", host.Address)
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(host, trustOnFirstUse, unknownHostKeyErr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	return &ssh.ClientConfig{
+		User:            host.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// sshHostKeyCallback returns the callback to verify the host key of a host. The host key is accepted when it matches a
+// pinned fingerprint or the known_hosts content. A host key, which doesn't match a pinned fingerprint or a key from the
+// known_hosts content for the same host, is always rejected. When trust on first use is enabled, an unknown host key is
+// saved as UnknownHostKeyError.
+func sshHostKeyCallback(host SSHHost, trustOnFirstUse bool, unknownHostKeyErr **UnknownHostKeyError) (ssh.HostKeyCallback, error) {
+	var knownHostsCallback ssh.HostKeyCallback
+
+	if host.KnownHosts != "" {
+		// The knownhosts package can only parse files, so that we have to write the content to a temporary file.
+		file, err := ioutil.TempFile("", "kubenav-known-hosts-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+
+		_, err = file.WriteString(host.KnownHosts)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		knownHostsCallback, err = knownhosts.New(file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("could not parse known hosts for %s: %w", host.Address, err)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, fingerprint := range host.Fingerprints {
+			if fingerprint == ssh.FingerprintSHA256(key) || fingerprint == ssh.FingerprintLegacyMD5(key) {
+				return nil
+			}
+		}
+
+		if knownHostsCallback != nil {
+			err := knownHostsCallback(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				return fmt.Errorf("host key verification failed for %s: %w", hostname, err)
+			}
+		}
+
+		if len(host.Fingerprints) > 0 {
+			return fmt.Errorf("host key verification failed for %s: fingerprint %s is not pinned", hostname, ssh.FingerprintSHA256(key))
+		}
+
+		if trustOnFirstUse {
+			*unknownHostKeyErr = &UnknownHostKeyError{Address: hostname, Key: key}
+			return *unknownHostKeyErr
+		}
+
+		return fmt.Errorf("host key verification failed for %s: host key %s is unknown", hostname, ssh.FingerprintSHA256(key))
+	}, nil
+}
+
+// startSSHProcess is called by sshHandler
+// Starts a shell on the target host of the connection and connects it up with the ptyHandler (a session)
+func startSSHProcess(conn *SSHConnection, ptyHandler PtyHandler) error {
+	session, err := conn.client.NewSession()
 	if err != nil {
 		return err
 	}
 	defer session.Close()
 
+	if conn.agent != nil {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return err
+		}
+	}
+
 	if err := session.RequestPty("xterm", 40, 80, ssh.TerminalModes{
 		ssh.ECHO:          1,
 		ssh.TTY_OP_ISPEED: 14400,
@@ -85,18 +342,22 @@ func startSSHProcess(key, addr, user string, ptyHandler PtyHandler) error {
 
 // WaitForSSH is called from execHandler as a goroutine
 // Waits for the SockJS connection to be opened by the client the session to be bound in handleSSHSession
-func WaitForSSH(key, addr, user string, sessionID string) {
-	select {
-	case <-TerminalSessions.Get(sessionID).Bound:
-		close(TerminalSessions.Get(sessionID).Bound)
+// Returns the error of the SSH session, so that the caller can record how the session was closed. The SSH connection is
+// closed, when the session is closed.
+func WaitForSSH(conn *SSHConnection, sessionID string) error {
+	defer conn.Close()
 
-		err := startSSHProcess(key, addr, user, TerminalSessions.Get(sessionID))
-		if err != nil {
-			log.WithError(err).Errorf("SSH session was closed")
-			TerminalSessions.Close(sessionID, 2, err.Error())
-			return
-		}
+	session, err := waitForBind(sessionID)
+	if err != nil {
+		return err
+	}
 
-		TerminalSessions.Close(sessionID, 1, "Process exited")
+	if err := startSSHProcess(conn, session); err != nil {
+		log.WithError(err).Errorf("SSH session was closed")
+		TerminalSessions.Close(sessionID, 2, err.Error())
+		return err
 	}
+
+	TerminalSessions.Close(sessionID, 1, "Process exited")
+	return nil
 }
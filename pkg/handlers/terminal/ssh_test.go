@@ -0,0 +1,78 @@
+package terminal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHHostKeyCallback(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("could not generate key: %v", err)
+		}
+
+		key, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			t.Fatalf("could not create public key: %v", err)
+		}
+
+		return key
+	}
+
+	hostKey := newKey()
+	otherKey := newKey()
+	address := "server.example.com:22"
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostKey) + "\n"
+	otherHostLine := knownhosts.Line([]string{knownhosts.Normalize("other.example.com:22")}, otherKey) + "\n"
+
+	for _, tc := range []struct {
+		name            string
+		host            SSHHost
+		trustOnFirstUse bool
+		key             ssh.PublicKey
+		expectError     bool
+		expectUnknown   bool
+	}{
+		{name: "no known hosts", host: SSHHost{Address: address}, key: hostKey, expectError: true},
+		{name: "no known hosts with trust on first use", host: SSHHost{Address: address}, trustOnFirstUse: true, key: hostKey, expectError: true, expectUnknown: true},
+		{name: "known host", host: SSHHost{Address: address, KnownHosts: knownHostsLine}, key: hostKey},
+		{name: "changed host key", host: SSHHost{Address: address, KnownHosts: knownHostsLine}, key: otherKey, expectError: true},
+		{name: "changed host key with trust on first use", host: SSHHost{Address: address, KnownHosts: knownHostsLine}, trustOnFirstUse: true, key: otherKey, expectError: true},
+		{name: "unknown host with trust on first use", host: SSHHost{Address: address, KnownHosts: otherHostLine}, trustOnFirstUse: true, key: hostKey, expectError: true, expectUnknown: true},
+		{name: "sha256 fingerprint", host: SSHHost{Address: address, Fingerprints: []string{ssh.FingerprintSHA256(hostKey)}}, key: hostKey},
+		{name: "md5 fingerprint", host: SSHHost{Address: address, Fingerprints: []string{ssh.FingerprintLegacyMD5(hostKey)}}, key: hostKey},
+		{name: "wrong fingerprint", host: SSHHost{Address: address, Fingerprints: []string{ssh.FingerprintSHA256(otherKey)}}, key: hostKey, expectError: true},
+		{name: "wrong fingerprint with trust on first use", host: SSHHost{Address: address, Fingerprints: []string{ssh.FingerprintSHA256(otherKey)}}, trustOnFirstUse: true, key: hostKey, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var unknownHostKeyErr *UnknownHostKeyError
+
+			callback, err := sshHostKeyCallback(tc.host, tc.trustOnFirstUse, &unknownHostKeyErr)
+			if err != nil {
+				t.Fatalf("could not create callback: %v", err)
+			}
+
+			err = callback(address, remote, tc.key)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tc.expectError, err)
+			}
+
+			var unknownErr *UnknownHostKeyError
+			if tc.expectUnknown != errors.As(err, &unknownErr) || tc.expectUnknown != (unknownHostKeyErr != nil) {
+				t.Errorf("expected unknown host key error %t, got %v", tc.expectUnknown, err)
+			}
+		})
+	}
+
+	if _, err := sshHostKeyCallback(SSHHost{Address: address, KnownHosts: "invalid known hosts"}, false, nil); err == nil {
+		t.Errorf("expected an error for invalid known hosts")
+	}
+}
@@ -7,15 +7,14 @@ package terminal
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/igm/sockjs-go.v2/sockjs"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
@@ -24,7 +23,7 @@ import (
 const END_OF_TRANSMISSION = "\u0004"
 
 // TerminalResponse is sent by execHandler. The ID is a random session id that binds the original REST request and the
-// SockJS connection. Any clientapi in possession of this Id can hijack the terminal session.
+// SockJS or WebSocket connection. The session can only be bound by the client which created it (see Owner).
 type TerminalResponse struct {
 	ID string `json:"id"`
 }
@@ -37,13 +36,22 @@ type PtyHandler interface {
 	GetSizeChan() chan remotecommand.TerminalSize
 }
 
-// TerminalSession implements PtyHandler (using a SockJS connection).
+// TerminalSession implements PtyHandler (using a SockJS or WebSocket connection). When the Transcript writer is set, the
+// output of the process is also written to the transcript, e.g. for the audit log. When the Recorder is set, the output
+// and all resize events are recorded in the asciicast format, so that the session can be replayed later. New sessions
+// should be created via NewTerminalSession, so that the session can be closed by the reaper.
 type TerminalSession struct {
-	ID            string
-	Bound         chan error
-	SockJSSession sockjs.Session
-	SizeChan      chan remotecommand.TerminalSize
-	DoneChan      chan struct{}
+	ID         string
+	Owner      string
+	Info       SessionInfo
+	Created    time.Time
+	Bound      chan TerminalSession
+	Conn       Conn
+	SizeChan   chan remotecommand.TerminalSize
+	DoneChan   chan struct{}
+	Transcript io.Writer
+	Recorder   *Recorder
+	stats      *sessionStats
 }
 
 // TerminalMessage is the messaging protocol between ShellController and TerminalSession.
@@ -54,6 +62,7 @@ type TerminalSession struct {
 // stdin   fe->be     Data           Keystrokes/paste buffer
 // resize  fe->be     Rows, Cols     New terminal size
 // stdout  be->fe     Data           Output from the process
+// resize  be->fe     Rows, Cols     Terminal size of a replayed recording
 type TerminalMessage struct {
 	Op, Data, SessionID string
 	Rows, Cols          uint16
@@ -78,21 +87,21 @@ func (t TerminalSession) GetSizeChan() chan remotecommand.TerminalSize {
 // Read handles pty->process messages (stdin, resize).
 // Called in a loop from remotecommand as long as the process is running.
 func (t TerminalSession) Read(p []byte) (int, error) {
-	m, err := t.SockJSSession.Recv()
+	msg, err := t.Conn.Recv()
 	if err != nil {
 		// Send terminated signal to process to avoid resource leak.
 		return copy(p, END_OF_TRANSMISSION), err
 	}
 
-	var msg TerminalMessage
-	if err := json.Unmarshal([]byte(m), &msg); err != nil {
-		return copy(p, END_OF_TRANSMISSION), err
-	}
+	t.stats.addIn(len(msg.Data))
 
 	switch msg.Op {
 	case "stdin":
 		return copy(p, msg.Data), nil
 	case "resize":
+		if t.Recorder != nil {
+			t.Recorder.Resize(msg.Cols, msg.Rows)
+		}
 		t.SizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
 		return 0, nil
 	default:
@@ -103,17 +112,25 @@ func (t TerminalSession) Read(p []byte) (int, error) {
 // Write handles process->pty stdout.
 // Called from remotecommand whenever there is any output.
 func (t TerminalSession) Write(p []byte) (int, error) {
-	msg, err := json.Marshal(TerminalMessage{
+	t.stats.addOut(len(p))
+
+	if err := t.Conn.Send(TerminalMessage{
 		Op:   "stdout",
 		Data: string(p),
-	})
-	if err != nil {
+	}); err != nil {
 		return 0, err
 	}
 
-	if err = t.SockJSSession.Send(string(msg)); err != nil {
-		return 0, err
+	if t.Transcript != nil {
+		if _, err := t.Transcript.Write(p); err != nil {
+			log.WithError(err).Errorf("Could not write terminal transcript")
+		}
+	}
+
+	if t.Recorder != nil {
+		t.Recorder.Output(p)
 	}
+
 	return len(p), nil
 }
 
@@ -137,15 +154,76 @@ func (sm *SessionMap) Set(sessionID string, session TerminalSession) {
 	sm.Sessions[sessionID] = session
 }
 
-// Close shuts down the SockJS connection and sends the status code and reason to the client.
+// Bind binds the connection to the session with the given id and returns the bound session. The session must exist,
+// must not be bound by another connection and must be owned by the client of the connection. The checks and the update
+// of the session are done while holding the lock, so that a session can not be bound by two connections.
+func (sm *SessionMap) Bind(sessionID string, conn Conn) (TerminalSession, error) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok {
+		return TerminalSession{}, fmt.Errorf("session not found")
+	}
+
+	if session.Conn != nil {
+		return TerminalSession{}, fmt.Errorf("session is already bound")
+	}
+
+	if session.Owner != conn.Client().Identity {
+		return TerminalSession{}, fmt.Errorf("session is owned by another client")
+	}
+
+	session.Conn = conn
+	sm.Sessions[sessionID] = session
+
+	return session, nil
+}
+
+// Close shuts down the SockJS or WebSocket connection and sends the status code and reason to the client.
 // Can happen if the process exits or if there is an error starting up the process.
 // For now the status code is unused and reason is shown to the user (unless "").
 func (sm *SessionMap) Close(sessionID string, status uint32, reason string) {
 	sm.Lock.Lock()
 	defer sm.Lock.Unlock()
-	err := sm.Sessions[sessionID].SockJSSession.Close(status, reason)
-	if err != nil {
-		log.WithError(err).Errorf("SockJS connection was closed")
+	sm.close(sessionID, status, reason)
+}
+
+// CloseAll shuts down all SockJS and WebSocket connections and sends the status code and reason to the clients. It is used to close
+// all active sessions, when the kubenav server is stopped.
+func (sm *SessionMap) CloseAll(status uint32, reason string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	for sessionID := range sm.Sessions {
+		sm.close(sessionID, status, reason)
+	}
+}
+
+// close shuts down the connection of a session, when the session was already bound, closes the recording of the
+// session and removes the session. The DoneChan is closed, so that a process which is waiting for the session to be
+// bound is stopped. The caller must hold the lock of the session map.
+func (sm *SessionMap) close(sessionID string, status uint32, reason string) {
+	session, ok := sm.Sessions[sessionID]
+	if !ok {
+		return
+	}
+
+	if session.Conn != nil {
+		err := session.Conn.Close(status, reason)
+		if err != nil {
+			log.WithError(err).Errorf("Terminal connection was closed")
+		}
+	}
+
+	if session.Recorder != nil {
+		if err := session.Recorder.Close(); err != nil {
+			log.WithError(err).Errorf("Could not close recording")
+		}
+	}
+
+	if session.DoneChan != nil {
+		close(session.DoneChan)
 	}
 
 	delete(sm.Sessions, sessionID)
@@ -154,43 +232,50 @@ func (sm *SessionMap) Close(sessionID string, status uint32, reason string) {
 // TerminalSessions holds all active terminal sessions.
 var TerminalSessions = SessionMap{Sessions: make(map[string]TerminalSession)}
 
-// handleTerminalSession is Called by net/http for any new /api/kubernetes/exec/sockjs connections.
-func handleTerminalSession(session sockjs.Session) {
+// handleTerminalSession is Called by net/http for any new /api/kubernetes/exec/sockjs, /api/kubernetes/ssh/sockjs and
+// WebSocket connections. When the connection can not be bound to a terminal session, the connection is closed.
+func handleTerminalSession(conn Conn) {
 	var (
-		buf             string
 		err             error
 		msg             TerminalMessage
 		terminalSession TerminalSession
 	)
 
-	if buf, err = session.Recv(); err != nil {
+	if msg, err = conn.Recv(); err != nil {
 		log.WithError(err).Errorf("handleTerminalSession: can't Recv")
-		return
-	}
-
-	if err = json.Unmarshal([]byte(buf), &msg); err != nil {
-		log.WithError(err).Errorf("handleTerminalSession: can't UnMarshal")
+		conn.Close(2, "Invalid message")
 		return
 	}
 
 	if msg.Op != "bind" {
-		log.WithFields(log.Fields{"message": buf}).Errorf("handleTerminalSession: expected 'bind' message")
+		log.WithFields(log.Fields{"op": msg.Op}).Errorf("handleTerminalSession: expected 'bind' message")
+		conn.Close(2, "Expected bind message")
 		return
 	}
 
-	if terminalSession = TerminalSessions.Get(msg.SessionID); terminalSession.ID == "" {
-		log.WithFields(log.Fields{"session": msg.SessionID}).Errorf("handleTerminalSession: can't find session")
+	if terminalSession, err = TerminalSessions.Bind(msg.SessionID, conn); err != nil {
+		log.WithError(err).WithFields(log.Fields{"session": msg.SessionID}).Errorf("handleTerminalSession: can't bind session")
+		conn.Close(2, "Session not found")
 		return
 	}
 
-	terminalSession.SockJSSession = session
-	TerminalSessions.Set(msg.SessionID, terminalSession)
-	terminalSession.Bound <- nil
+	select {
+	case terminalSession.Bound <- terminalSession:
+	case <-terminalSession.DoneChan:
+		conn.Close(2, "Session was closed")
+	}
 }
 
 // CreateAttachHandler is called from main for /api/kubernetes/exec/sockjs.
-func CreateAttachHandler(path string) http.Handler {
-	return sockjs.NewHandler(path, sockjs.DefaultOptions, handleTerminalSession)
+func CreateAttachHandler(path string, client ClientFunc) http.Handler {
+	return newSockJSHandler(path, client, handleTerminalSession)
+}
+
+// CreateWebSocketHandler is called from main for /api/kubernetes/exec/ws and /api/kubernetes/ssh/ws. It implements the
+// same protocol as the SockJS handlers via a plain WebSocket connection, so that a client doesn't need a SockJS
+// library to attach to a terminal session.
+func CreateWebSocketHandler(client ClientFunc, checkOrigin OriginFunc) http.Handler {
+	return newWebSocketHandler(client, checkOrigin, handleTerminalSession)
 }
 
 // startProcess is called by execHandler.
@@ -216,7 +301,7 @@ func startProcess(config *rest.Config, clientset *kubernetes.Clientset, reqURL *
 }
 
 // GenTerminalSessionID generates a random session ID string. The format is not really interesting.
-// This ID is used to identify the session when the client opens the SockJS connection.
+// This ID is used to identify the session when the client opens the SockJS or WebSocket connection.
 // Not the same as the SockJS session id! We can't use that as that is generated on the client side and we don't have it
 // yet at this point.
 func GenTerminalSessionID() (string, error) {
@@ -240,34 +325,73 @@ func isValidShell(validShells []string, shell string) bool {
 }
 
 // WaitForTerminal is called from execHandler as a goroutine.
-// Waits for the SockJS connection to be opened by the client the session to be bound in handleTerminalSession.
-func WaitForTerminal(config *rest.Config, clientset *kubernetes.Clientset, reqURL *url.URL, shell string, sessionID string) {
-	select {
-	case <-TerminalSessions.Get(sessionID).Bound:
-		close(TerminalSessions.Get(sessionID).Bound)
-
-		var err error
-		validShells := []string{"bash", "sh", "powershell", "cmd"}
-
-		if isValidShell(validShells, shell) {
-			cmd := []string{shell}
-			err = startProcess(config, clientset, reqURL, cmd, TerminalSessions.Get(sessionID))
-		} else {
-			// No shell given or it was not valid: try some shells until one succeeds or all fail.
-			for _, testShell := range validShells {
-				cmd := []string{testShell}
-				if err = startProcess(config, clientset, reqURL, cmd, TerminalSessions.Get(sessionID)); err == nil {
-					break
-				}
+// Waits for the SockJS or WebSocket connection to be opened by the client the session to be bound in handleTerminalSession.
+// Returns the error of the process, so that the caller can record how the session was closed.
+func WaitForTerminal(config *rest.Config, clientset *kubernetes.Clientset, reqURL *url.URL, shell string, sessionID string) error {
+	session, err := waitForBind(sessionID)
+	if err != nil {
+		return err
+	}
+
+	validShells := []string{"bash", "sh", "powershell", "cmd"}
+
+	if isValidShell(validShells, shell) {
+		cmd := []string{shell}
+		err = startProcess(config, clientset, reqURL, cmd, session)
+	} else {
+		// No shell given or it was not valid: try some shells until one succeeds or all fail.
+		for _, testShell := range validShells {
+			cmd := []string{testShell}
+			if err = startProcess(config, clientset, reqURL, cmd, session); err == nil {
+				break
 			}
 		}
+	}
 
-		if err != nil {
-			log.WithError(err).Errorf("Terminal session was closed")
-			TerminalSessions.Close(sessionID, 2, err.Error())
-			return
-		}
+	if err != nil {
+		log.WithError(err).Errorf("Terminal session was closed")
+		TerminalSessions.Close(sessionID, 2, err.Error())
+		return err
+	}
+
+	TerminalSessions.Close(sessionID, 1, "Process exited")
+	return nil
+}
+
+// WaitForAttach is called from execHandler and debugHandler as a goroutine.
+// Waits for the SockJS or WebSocket connection to be opened by the client and attaches the session to the running
+// process of the container specified in the request URL. The request URL must be a "pods/attach" URL.
+func WaitForAttach(config *rest.Config, reqURL *url.URL, options AttachOptions, sessionID string) error {
+	session, err := waitForBind(sessionID)
+	if err != nil {
+		return err
+	}
 
-		TerminalSessions.Close(sessionID, 1, "Process exited")
+	if err := startAttachProcess(config, reqURL, options, session); err != nil {
+		log.WithError(err).Errorf("Terminal session was closed")
+		TerminalSessions.Close(sessionID, 2, err.Error())
+		return err
+	}
+
+	TerminalSessions.Close(sessionID, 1, "Process exited")
+	return nil
+}
+
+// waitForBind waits until the session with the given id is bound to a connection and returns the bound session. The
+// session is sent by handleTerminalSession, so that the connection of the returned session is always set, even when the
+// session is closed in the meantime. An error is returned, when the session doesn't exist or was closed before it was
+// bound.
+func waitForBind(sessionID string) (TerminalSession, error) {
+	session := TerminalSessions.Get(sessionID)
+	if session.Bound == nil {
+		return TerminalSession{}, fmt.Errorf("session not found")
+	}
+
+	select {
+	case <-session.DoneChan:
+		return TerminalSession{}, fmt.Errorf("session was closed before it was bound")
+	case bound := <-session.Bound:
+		close(session.Bound)
+		return bound, nil
 	}
 }
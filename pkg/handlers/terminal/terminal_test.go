@@ -0,0 +1,105 @@
+package terminal
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testConn is a connection for tests, which only has an identity.
+type testConn struct {
+	identity string
+}
+
+func (c *testConn) Recv() (TerminalMessage, error)           { return TerminalMessage{}, nil }
+func (c *testConn) Send(msg TerminalMessage) error           { return nil }
+func (c *testConn) Close(status uint32, reason string) error { return nil }
+func (c *testConn) Client() Client                           { return Client{Identity: c.identity} }
+
+func TestSessionMapBind(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		sessionID   string
+		owner       string
+		bound       bool
+		identity    string
+		expectError bool
+	}{
+		{name: "owner", sessionID: "session", owner: "user:alice", identity: "user:alice"},
+		{name: "owner without authentication", sessionID: "session", owner: "", identity: ""},
+		{name: "other client", sessionID: "session", owner: "user:alice", identity: "user:bob", expectError: true},
+		{name: "client without identity", sessionID: "session", owner: "user:alice", identity: "", expectError: true},
+		{name: "already bound", sessionID: "session", owner: "user:alice", bound: true, identity: "user:alice", expectError: true},
+		{name: "unknown session", sessionID: "unknown", owner: "user:alice", identity: "user:alice", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sm := SessionMap{Sessions: make(map[string]TerminalSession)}
+
+			session := NewTerminalSession("session", tc.owner, SessionInfo{})
+			if tc.bound {
+				session.Conn = &testConn{identity: tc.owner}
+			}
+			sm.Set("session", session)
+
+			conn := &testConn{identity: tc.identity}
+			bound, err := sm.Bind(tc.sessionID, conn)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if bound.Conn != conn || sm.Get(tc.sessionID).Conn != conn {
+				t.Errorf("expected the session to be bound to the connection")
+			}
+		})
+	}
+}
+
+func TestSessionMapBindConcurrent(t *testing.T) {
+	sm := SessionMap{Sessions: make(map[string]TerminalSession)}
+	sm.Set("session", NewTerminalSession("session", "user:alice", SessionInfo{}))
+
+	var wg sync.WaitGroup
+	var bound int64
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sm.Bind("session", &testConn{identity: "user:alice"}); err == nil {
+				atomic.AddInt64(&bound, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if bound != 1 {
+		t.Errorf("expected the session to be bound once, got %d", bound)
+	}
+}
+
+func TestWaitForBind(t *testing.T) {
+	if _, err := waitForBind("unknown"); err == nil {
+		t.Errorf("expected an error for an unknown session")
+	}
+
+	TerminalSessions.Set("session", NewTerminalSession("session", "user:alice", SessionInfo{}))
+	conn := &replayConn{client: Client{Identity: "user:alice"}, bind: "session"}
+	go handleTerminalSession(conn)
+
+	session, err := waitForBind("session")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The session is closed after it was bound, the returned session must still contain the connection.
+	TerminalSessions.Close("session", 1, "Session was closed")
+	if session.Conn != conn {
+		t.Errorf("expected the bound session to contain the connection")
+	}
+}
@@ -0,0 +1,402 @@
+// Package watch implements the streaming of changes for Kubernetes resources to the frontend. Instead of polling the
+// Kubernetes API for changes, the frontend opens a watch session, which lists the requested resources once and then
+// uses the watch API of Kubernetes to forward all changes via Server Sent Events.
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/api/middleware"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// minRetryDelay and maxRetryDelay are the bounds of the exponential backoff between the restarts of a watch request,
+	// which was closed without new events or failed with a transient error.
+	minRetryDelay = 1 * time.Second
+	maxRetryDelay = 30 * time.Second
+	// maxRetries is the number of restarts in a row without new events, after which the watch session is closed.
+	maxRetries = 5
+)
+
+// Session is the structure of a watch session, which consists of a Kubernetes clientset and the URL of the list
+// request for the resources which should be watched. The owner is the identity of the client which created the session,
+// only this client can start the watch stream. Bound is set, when the client started the watch stream. New sessions
+// should be created via NewSession, so that they can be closed via the session management API.
+type Session struct {
+	ClientSet *kubernetes.Clientset
+	URL       string
+	Cluster   string
+	Owner     string
+	Created   time.Time
+	Bound     bool
+	done      chan struct{}
+}
+
+// NewSession returns a new watch session for the given list URL.
+func NewSession(clientset *kubernetes.Clientset, listURL, cluster, owner string) Session {
+	return Session{
+		ClientSet: clientset,
+		URL:       listURL,
+		Cluster:   cluster,
+		Owner:     owner,
+		Created:   time.Now(),
+		done:      make(chan struct{}),
+	}
+}
+
+// SessionMap stores a map of all Session objects and a lock to avoid concurrent conflict.
+type SessionMap struct {
+	Sessions map[string]Session
+	Lock     sync.RWMutex
+}
+
+// Get return a given watch session by sessionID.
+func (sm *SessionMap) Get(sessionID string) (Session, bool) {
+	sm.Lock.RLock()
+	defer sm.Lock.RUnlock()
+
+	session, ok := sm.Sessions[sessionID]
+	return session, ok
+}
+
+// Set store a Session to SessionMap.
+func (sm *SessionMap) Set(sessionID string, session Session) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sm.Sessions[sessionID] = session
+}
+
+// Bind marks the session with the given id as bound and returns the session. It returns false, when the session doesn't
+// exist, is already bound or is owned by another client.
+func (sm *SessionMap) Bind(sessionID, identity string) (Session, bool) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok || session.Bound || session.Owner != identity {
+		return Session{}, false
+	}
+
+	session.Bound = true
+	sm.Sessions[sessionID] = session
+	return session, true
+}
+
+// Reap removes all sessions, which were not bound within the given timeout.
+func (sm *SessionMap) Reap(bindTimeout time.Duration) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	if bindTimeout <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	for sessionID, session := range sm.Sessions {
+		if !session.Bound && now.Sub(session.Created) > bindTimeout {
+			log.WithFields(log.Fields{"session": sessionID}).Infof("Remove unbound watch session")
+			delete(sm.Sessions, sessionID)
+		}
+	}
+}
+
+// Delete removes a session from the active sessions.
+func (sm *SessionMap) Delete(sessionID string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	if _, ok := sm.Sessions[sessionID]; ok {
+		delete(sm.Sessions, sessionID)
+	}
+}
+
+// Close stops the watch stream of the session with the given id and removes the session. It returns false, when the
+// session doesn't exist.
+func (sm *SessionMap) Close(sessionID string) bool {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+
+	session, ok := sm.Sessions[sessionID]
+	if !ok {
+		return false
+	}
+
+	if session.done != nil {
+		close(session.done)
+	}
+
+	delete(sm.Sessions, sessionID)
+	return true
+}
+
+// DeleteAll removes all sessions from the active sessions. Active watch streams are closed via the context of the
+// request.
+func (sm *SessionMap) DeleteAll() {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sm.Sessions = make(map[string]Session)
+}
+
+// Sessions holds all active watch sessions.
+var Sessions = SessionMap{Sessions: make(map[string]Session)}
+
+// Event is the structure of a single event, which is sent to the frontend. The type is one of "LIST", "ADDED",
+// "MODIFIED" or "DELETED". For the "LIST" event the object contains the complete list of resources, which should
+// replace the current state in the frontend. For all other events the object contains the changed resource.
+type Event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// object is used to get the resource version from a list or from a single resource.
+type object struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+}
+
+// status is used to get the status code from an "ERROR" event returned by the Kubernetes API.
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// errGone is returned when the resource version used for the watch request is too old. In this case we have to list
+// all resources again to get a new resource version.
+var errGone = fmt.Errorf("resource version is too old")
+
+// StreamWatchHandler handles the requests to watch Kubernetes resources. The handler lists all resources and then
+// starts a watch request with the returned resource version. When the watch request is closed by the API server or
+// fails with a transient error, it is restarted with the last seen resource version. When the resource version is too
+// old (410 Gone) we list all resources again. When a request doesn't return new events, the next request is delayed
+// with an exponential backoff and the session is closed after maxRetries requests in a row. The stream is also closed,
+// when the session is closed via the session management API.
+func StreamWatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	params := strings.Split(r.URL.Path, "/")
+	sessionID := params[len(params)-1]
+	session, ok := Sessions.Bind(sessionID, middleware.Identity(r))
+	if !ok {
+		log.Error("Watch session not found")
+		http.Error(w, "Watch session not found", http.StatusNotFound)
+		return
+	}
+	defer Sessions.Delete(sessionID)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	if session.done != nil {
+		go func() {
+			select {
+			case <-session.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	listURL, err := url.Parse(session.URL)
+	if err != nil {
+		log.WithError(err).Errorf("Could not parse watch url")
+		return
+	}
+
+	query := listURL.Query()
+	query.Del("watch")
+	query.Del("resourceVersion")
+	listURL.RawQuery = query.Encode()
+
+	resourceVersion := ""
+	retries := 0
+
+	for {
+		previousResourceVersion := resourceVersion
+
+		if resourceVersion == "" {
+			resourceVersion, err = list(ctx, w, session.ClientSet, listURL)
+		}
+		if err == nil {
+			resourceVersion, err = watch(ctx, w, session.ClientSet, listURL, resourceVersion)
+		}
+
+		if ctx.Err() != nil {
+			log.Debugf("Watch session was closed")
+			return
+		}
+
+		if err == errGone || apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+			log.WithError(err).Debugf("Resource version is too old, list resources again")
+			resourceVersion = ""
+		} else if err != nil && !isTransient(err) {
+			log.WithError(err).Errorf("Watch session was closed")
+			return
+		}
+
+		// When the request returned new events, we restart the watch request immediately. Otherwise we wait before the
+		// next request, so that we do not flood the API server, when it closes or rejects all watch requests.
+		if err == nil && resourceVersion != previousResourceVersion {
+			retries = 0
+			continue
+		}
+
+		retries++
+		if retries > maxRetries {
+			log.WithError(err).Errorf("Watch session was closed after %d retries", maxRetries)
+			return
+		}
+
+		log.WithError(err).WithFields(log.Fields{"session": sessionID, "retries": retries}).Debugf("Restart watch request")
+
+		select {
+		case <-ctx.Done():
+			log.Debugf("Watch session was closed")
+			return
+		case <-time.After(retryDelay(retries)):
+		}
+	}
+}
+
+// isTransient returns true, when a list or watch request failed with an error, which may be gone when the request is
+// retried, e.g. a network error or an internal error of the API server. Errors like a missing permission are not
+// transient.
+func isTransient(err error) bool {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := int(status.Status().Code)
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err)
+	}
+
+	return true
+}
+
+// retryDelay returns the delay before the given retry of a watch request.
+func retryDelay(retry int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay = delay * 2
+	}
+
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
+
+// list returns all resources for the given url as "LIST" event and returns the resource version of the list.
+func list(ctx context.Context, w http.ResponseWriter, clientset *kubernetes.Clientset, listURL *url.URL) (string, error) {
+	result, err := clientset.RESTClient().Get().RequestURI(listURL.String()).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var list object
+	if err := json.Unmarshal(result, &list); err != nil {
+		return "", err
+	}
+
+	if err := write(w, Event{Type: "LIST", Object: result}); err != nil {
+		return "", err
+	}
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch starts a watch request for the given url and resource version. All "ADDED", "MODIFIED" and "DELETED" events
+// are forwarded to the client. When the API server closes the watch request, the function returns the last seen
+// resource version, so that the caller can restart the watch request.
+func watch(ctx context.Context, w http.ResponseWriter, clientset *kubernetes.Clientset, listURL *url.URL, resourceVersion string) (string, error) {
+	watchURL := *listURL
+	query := watchURL.Query()
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("resourceVersion", resourceVersion)
+	watchURL.RawQuery = query.Encode()
+
+	readCloser, err := clientset.RESTClient().Get().RequestURI(watchURL.String()).Stream(ctx)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer readCloser.Close()
+
+	decoder := json.NewDecoder(readCloser)
+
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return resourceVersion, nil
+			}
+
+			return resourceVersion, err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED", "BOOKMARK":
+			var obj object
+			if err := json.Unmarshal(event.Object, &obj); err != nil {
+				return resourceVersion, err
+			}
+
+			if obj.Metadata.ResourceVersion != "" {
+				resourceVersion = obj.Metadata.ResourceVersion
+			}
+
+			if event.Type == "BOOKMARK" {
+				continue
+			}
+
+			if err := write(w, event); err != nil {
+				return resourceVersion, err
+			}
+		case "ERROR":
+			var s status
+			if err := json.Unmarshal(event.Object, &s); err != nil {
+				return resourceVersion, err
+			}
+
+			if s.Code == http.StatusGone {
+				return "", errGone
+			}
+
+			return resourceVersion, &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    int32(s.Code),
+				Message: fmt.Sprintf("watch request failed: %s", s.Message),
+			}}
+		}
+	}
+}
+
+// write sends an event to the client.
+func write(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte(fmt.Sprintf("data: %s\n\n", string(data)))); err != nil {
+		return err
+	}
+
+	w.(http.Flusher).Flush()
+	return nil
+}
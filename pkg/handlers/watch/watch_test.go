@@ -0,0 +1,78 @@
+package watch
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for _, tc := range []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{retry: 1, expected: 1 * time.Second},
+		{retry: 2, expected: 2 * time.Second},
+		{retry: 3, expected: 4 * time.Second},
+		{retry: maxRetries, expected: 16 * time.Second},
+		{retry: 100, expected: 30 * time.Second},
+	} {
+		if actual := retryDelay(tc.retry); actual != tc.expected {
+			t.Errorf("retryDelay(%d) = %s, expected %s", tc.retry, actual, tc.expected)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	resource := schema.GroupResource{Resource: "pods"}
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "network error", err: io.ErrUnexpectedEOF, expected: true},
+		{name: "other error", err: fmt.Errorf("connection reset by peer"), expected: true},
+		{name: "internal error", err: apierrors.NewInternalError(fmt.Errorf("etcd is unavailable")), expected: true},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("unavailable"), expected: true},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 1), expected: true},
+		{name: "timeout", err: apierrors.NewTimeoutError("timeout", 1), expected: true},
+		{name: "forbidden", err: apierrors.NewForbidden(resource, "", fmt.Errorf("forbidden")), expected: false},
+		{name: "not found", err: apierrors.NewNotFound(resource, "nginx"), expected: false},
+		{name: "wrapped forbidden", err: fmt.Errorf("watch failed: %w", apierrors.NewForbidden(resource, "", fmt.Errorf("forbidden"))), expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isTransient(tc.err); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSessionMapClose(t *testing.T) {
+	sessions := SessionMap{Sessions: make(map[string]Session)}
+	session := NewSession(nil, "/api/v1/namespaces/default/pods", "cluster", "user:alice")
+	sessions.Set("session", session)
+
+	if !sessions.Close("session") {
+		t.Fatalf("expected the session to be closed")
+	}
+
+	select {
+	case <-session.done:
+	default:
+		t.Errorf("expected the watch stream of the session to be stopped")
+	}
+
+	if _, ok := sessions.Get("session"); ok {
+		t.Errorf("expected the session to be removed")
+	}
+
+	if sessions.Close("session") {
+		t.Errorf("expected no session to be closed")
+	}
+}
@@ -16,22 +16,26 @@ import (
 
 // Client implements the structure of an Kubernetes API client.
 // The server, desktop and mobile implementation share the same structure for the Kubernetes API client, also when not
-// all methods (arguments) are really needed.
+// all methods (arguments) are really needed. New authentication options should be added to the ClusterCredentials
+// struct, so that the signature of the GetConfigAndClientset method must not be changed.
 type Client interface {
-	GetConfigAndClientset(cluster, server, certificateAuthorityData, clientCertificateData, clientKeyData, token, username, password string, insecureSkipTLSVerify bool, timeout time.Duration, proxy string) (*rest.Config, *kubernetes.Clientset, error)
+	GetConfigAndClientset(credentials types.ClusterCredentials, timeout time.Duration) (*rest.Config, *kubernetes.Clientset, error)
 	Cluster() (string, error)
 	Clusters() (map[string]types.Cluster, error)
 	ChangeContext(context string) error
 	ChangeNamespace(context, namespace string) error
+	CachedRequest(cluster, url string) ([]byte, bool, error)
+	CacheStatus() (map[string]types.CacheStatus, error)
+	Close()
 }
 
 // NewClient returns a new Kubernetes API client.
 // The mobile version of kubenav needs no additional parameters, but for the server and desktop version we provide more
 // configuration options which are set via command-line arguments and therefor we have to pass them to the client.
-func NewClient(isMobile bool, incluster bool, kubeconfig string, kubeconfigInclude string, kubeconfigExclude string) (Client, error) {
+func NewClient(isMobile bool, incluster bool, kubeconfig string, kubeconfigInclude string, kubeconfigExclude string, cache bool) (Client, error) {
 	if isMobile {
 		return mobile.NewClient()
 	}
 
-	return server.NewClient(incluster, kubeconfig, kubeconfigInclude, kubeconfigExclude)
+	return server.NewClient(incluster, kubeconfig, kubeconfigInclude, kubeconfigExclude, cache)
 }
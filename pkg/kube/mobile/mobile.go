@@ -2,27 +2,35 @@
 package mobile
 
 import (
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/kubenav/kubenav/pkg/kube/pool"
 	"github.com/kubenav/kubenav/pkg/kube/types"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 // Client implements an API client for the Kubernetes API.
 // On mobile we do not realy uses this client, since all configration options are accessed via the frontend and they are
 // sent with every request.
-type Client struct{}
+// To reuse the connections to the Kubernetes API server, the created clients are cached in a pool.
+type Client struct {
+	pool *pool.Pool
+}
 
 // NewClient returns a new API client for Kubernetes.
 func NewClient() (*Client, error) {
-	return &Client{}, nil
+	return &Client{
+		pool: pool.New(pool.DefaultTTL),
+	}, nil
 }
 
 // Cluster is only used for the server and desktop version of kubenav and not implemented for the mobile version.
@@ -45,57 +53,156 @@ func (c *Client) ChangeNamespace(context, namespace string) error {
 	return fmt.Errorf("Not implemented")
 }
 
+// CachedRequest is only used for the server and desktop version of kubenav and not implemented for the mobile version.
+// Requests are never answered from the cache, so that they are always sent to the Kubernetes API server.
+func (c *Client) CachedRequest(cluster, url string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+// CacheStatus is only used for the server and desktop version of kubenav and not implemented for the mobile version.
+func (c *Client) CacheStatus() (map[string]types.CacheStatus, error) {
+	return nil, fmt.Errorf("Not implemented")
+}
+
+// Close removes all clients from the pool.
+func (c *Client) Close() {
+	c.pool.Close()
+}
+
 // GetConfigAndClientset returns an rest client and the clientset to interact with a Kubernetes cluster.
-// The mobile implementation uses every argument, expect the "cluster", because we have to sent the cluster
-// configuration with every API request.
-func (c *Client) GetConfigAndClientset(cluster, server, certificateAuthorityData, clientCertificateData, clientKeyData, token, username, password string, insecureSkipTLSVerify bool, timeout time.Duration, proxy string) (*rest.Config, *kubernetes.Clientset, error) {
-	config, err := clientcmd.NewClientConfigFromBytes([]byte(`apiVersion: v1
-clusters:
-- cluster:
-    certificate-authority-data: ` + base64.StdEncoding.EncodeToString([]byte(certificateAuthorityData)) + `
-    insecure-skip-tls-verify: ` + fmt.Sprintf("%t", insecureSkipTLSVerify) + `
-    server: ` + server + `
-  name: kubenav
-contexts:
-- context:
-    cluster: kubenav
-    user: kubenav
-  name: kubenav
-current-context: kubenav
-kind: Config
-users:
-- name: kubenav
-  user:
-    client-certificate-data: ` + base64.StdEncoding.EncodeToString([]byte(clientCertificateData)) + `
-    client-key-data: ` + base64.StdEncoding.EncodeToString([]byte(clientKeyData)) + `
-    password: ` + password + `
-    token: ` + token + `
-    username: ` + username))
+// The mobile implementation uses every field of the credentials, expect the "cluster", because we have to sent the
+// cluster configuration with every API request. The cluster is only used to remove the cached clients from the pool,
+// when the credentials (without the impersonation fields) for a cluster are changed.
+func (c *Client) GetConfigAndClientset(credentials types.ClusterCredentials, timeout time.Duration) (*rest.Config, *kubernetes.Clientset, error) {
+	if err := credentials.ValidateImpersonation(); err != nil {
+		return nil, nil, err
+	}
 
+	credentials.Server = serverURL(credentials.Server)
+
+	// The impersonation fields are only used as identity for the pool, so that a change of the impersonated user
+	// doesn't remove the clients of other users.
+	realCredentials := credentials
+	realCredentials.ImpersonateUser = ""
+	realCredentials.ImpersonateGroups = nil
+	realCredentials.ImpersonateExtra = nil
+
+	credentialsData, err := json.Marshal(realCredentials)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	restClient, err := config.ClientConfig()
+	identityData, err := json.Marshal([]interface{}{credentials.ImpersonateUser, credentials.ImpersonateGroups, credentials.ImpersonateExtra})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	restClient.Timeout = timeout
+	return c.pool.Get(credentials.Cluster, []string{string(credentialsData)}, []string{string(identityData)}, timeout, credentials.Proxy, func() (*rest.Config, *kubernetes.Clientset, error) {
+		restClient, err := clientcmd.NewDefaultClientConfig(kubeconfig(credentials), &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		restClient.Timeout = timeout
+
+		if credentials.Proxy != "" {
+			proxyURL, err := url.Parse(credentials.Proxy)
+			if err != nil {
+				return nil, nil, err
+			}
 
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
+			restClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		}
+
+		clientset, err := kubernetes.NewForConfig(restClient)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		restClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		return restClient, clientset, nil
+	})
+}
+
+// kubeconfig returns a Kubeconfig with a single cluster, user and context for the given credentials.
+func kubeconfig(credentials types.ClusterCredentials) clientcmdapi.Config {
+	authInfo := &clientcmdapi.AuthInfo{
+		ClientCertificateData: []byte(credentials.ClientCertificateData),
+		ClientKeyData:         []byte(credentials.ClientKeyData),
+		Token:                 credentials.Token,
+		Username:              credentials.Username,
+		Password:              credentials.Password,
+		Impersonate:           credentials.ImpersonateUser,
+		ImpersonateGroups:     credentials.ImpersonateGroups,
+		ImpersonateUserExtra:  credentials.ImpersonateExtra,
+	}
+
+	if credentials.AuthProvider != nil {
+		authInfo.AuthProvider = &clientcmdapi.AuthProviderConfig{
+			Name:   credentials.AuthProvider.Name,
+			Config: authProviderConfig(credentials.AuthProvider.Config),
+		}
+	}
+
+	return clientcmdapi.Config{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: "kubenav",
+		Contexts: map[string]*clientcmdapi.Context{
+			"kubenav": {
+				Cluster:  "kubenav",
+				AuthInfo: "kubenav",
+			},
+		},
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"kubenav": {
+				Server:                   credentials.Server,
+				CertificateAuthorityData: []byte(credentials.CertificateAuthorityData),
+				InsecureSkipTLSVerify:    credentials.InsecureSkipTLSVerify,
+				TLSServerName:            credentials.TLSServerName,
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"kubenav": authInfo,
+		},
+	}
+}
+
+// unsafeAuthProviderKeys are the keys of an auth provider config, which are used to run a command or to read a local
+// file. They are removed, because the config is sent with every request and every caller of the API could use them.
+var unsafeAuthProviderKeys = []string{"cmd-path", "cmd-args", "idp-certificate-authority"}
+
+// authProviderConfig returns a copy of the auth provider config without the unsafe keys.
+func authProviderConfig(config map[string]string) map[string]string {
+	safeConfig := make(map[string]string, len(config))
+	for key, value := range config {
+		safeConfig[key] = value
 	}
 
-	clientset, err := kubernetes.NewForConfig(restClient)
+	for _, key := range unsafeAuthProviderKeys {
+		delete(safeConfig, key)
+	}
+
+	return safeConfig
+}
+
+// serverURL returns the URL of the Kubernetes API server for the given request URL. On mobile the frontend sends the
+// complete URL of the request as server, so that we have to remove the path of the request. Otherwise we would create
+// a new client for every requested URL. A path prefix before the API path (e.g. when Rancher is used as proxy) is kept.
+func serverURL(server string) string {
+	u, err := url.Parse(server)
 	if err != nil {
-		return nil, nil, err
+		return server
 	}
 
-	return restClient, clientset, nil
+	for _, prefix := range []string{"/api/", "/apis/"} {
+		if index := strings.Index(u.Path+"/", prefix); index != -1 {
+			u.Path = u.Path[:index]
+			break
+		}
+	}
+
+	u.RawQuery = ""
+	u.RawPath = ""
+
+	return u.String()
 }
@@ -0,0 +1,317 @@
+// Package pool implements a pool for Kubernetes API clients, which is shared by the mobile and the server
+// implementation of the Kubernetes API client. Instead of creating a new rest config and clientset for every request,
+// the clients are cached by a hash of the cluster, the credentials, the impersonated identity, the proxy and the
+// timeout. This allows us to reuse the underlying HTTP connections of a clientset.
+package pool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultTTL is the time after which an unused client is removed from the pool.
+const DefaultTTL = 10 * time.Minute
+
+// Metrics is notified about the usage of all pools, e.g. to export the hit rate of the pools as Prometheus metrics. The
+// pool package doesn't depend on Prometheus, so that it isn't part of the mobile build. The Prometheus implementation
+// is set by the server and desktop implementation via SetMetrics (see the metrics package).
+type Metrics interface {
+	// Hit is called, when a client was served from the pool.
+	Hit()
+	// Miss is called, when a new client must be created.
+	Miss()
+	// Added is called, when a new client was added to the pool.
+	Added()
+	// Evicted is called, when a client was removed from the pool. The reason is "ttl", "credentials", "invalidated" or
+	// "closed".
+	Evicted(reason string)
+}
+
+// noopMetrics is the default Metrics implementation, which ignores all calls.
+type noopMetrics struct{}
+
+func (noopMetrics) Hit()           {}
+func (noopMetrics) Miss()          {}
+func (noopMetrics) Added()         {}
+func (noopMetrics) Evicted(string) {}
+
+var metrics Metrics = noopMetrics{}
+
+// SetMetrics sets the Metrics implementation, which is notified about the usage of all pools. It must be called before
+// the pools are used. When the given implementation is nil, the metrics are disabled.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+
+	metrics = m
+}
+
+// CreateFunc is the function, which is called to create a new rest config and clientset, when the pool doesn't
+// contain a client for the requested key.
+type CreateFunc func() (*rest.Config, *kubernetes.Clientset, error)
+
+// entry is a single client in the pool.
+type entry struct {
+	cluster   string
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	lastUsed  time.Time
+}
+
+// call is the creation of a client, which is in progress. Concurrent requests for the same key wait until the call is
+// done, instead of creating another client.
+type call struct {
+	done      chan struct{}
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	err       error
+}
+
+// Pool holds all cached clients. The clients are saved by a hash of the cluster, credentials, identity, timeout and
+// proxy. For each cluster we also save the hash of the last used credentials, so that we can remove all clients for a
+// cluster when the credentials are changed. The identity isn't part of this hash, so that requests of different users
+// can use their clients at the same time. New clients are created without holding the lock of the pool, so that a slow
+// creation doesn't block the requests for other clients.
+type Pool struct {
+	entries     map[string]*entry
+	calls       map[string]*call
+	credentials map[string]string
+	ttl         time.Duration
+	lock        sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// New returns a new pool. Clients which were not used for the given ttl are removed from the pool by a background
+// goroutine, which runs until the pool is closed.
+func New(ttl time.Duration) *Pool {
+	p := &Pool{
+		entries:     make(map[string]*entry),
+		calls:       make(map[string]*call),
+		credentials: make(map[string]string),
+		ttl:         ttl,
+		done:        make(chan struct{}),
+	}
+
+	go p.evict()
+
+	return p
+}
+
+// Close stops the background goroutine of the pool and removes all clients. The idle connections of the removed
+// clients are closed.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		for key := range p.entries {
+			p.remove(key, "closed")
+		}
+		p.credentials = make(map[string]string)
+	})
+}
+
+// Get returns the rest config and clientset for the given cluster from the pool. The credentials slice must contain
+// all values, which are used to authenticate against the cluster. The identity slice must contain all values, which
+// are used to impersonate another user. If the pool doesn't contain a client for the cluster, credentials, identity,
+// timeout and proxy, the create function is called and the returned client is added to the pool. Concurrent requests
+// for the same client are waiting for the first request, so that the create function is only called once.
+// The returned rest config is a copy of the cached config, so that the caller can modify it.
+func (p *Pool) Get(cluster string, credentials, identity []string, timeout time.Duration, proxy string, create CreateFunc) (*rest.Config, *kubernetes.Clientset, error) {
+	credentialsHash := CredentialsHash(cluster, credentials)
+	key := hash([]string{credentialsHash, hash(identity), timeout.String(), proxy})
+
+	p.lock.Lock()
+
+	if oldCredentialsHash, ok := p.credentials[cluster]; ok && oldCredentialsHash != credentialsHash {
+		log.WithFields(log.Fields{"cluster": cluster}).Debugf("Credentials were changed, remove clients from pool")
+		p.deleteCluster(cluster, "credentials")
+	}
+	p.credentials[cluster] = credentialsHash
+
+	if e, ok := p.entries[key]; ok {
+		metrics.Hit()
+		e.lastUsed = time.Now()
+		p.lock.Unlock()
+		return rest.CopyConfig(e.config), e.clientset, nil
+	}
+
+	if c, ok := p.calls[key]; ok {
+		p.lock.Unlock()
+		<-c.done
+
+		if c.err != nil {
+			return nil, nil, c.err
+		}
+
+		metrics.Hit()
+		return rest.CopyConfig(c.config), c.clientset, nil
+	}
+
+	metrics.Miss()
+	c := &call{done: make(chan struct{})}
+	p.calls[key] = c
+	p.lock.Unlock()
+
+	c.config, c.clientset, c.err = create()
+
+	// The client is only added to the pool, when the credentials of the cluster weren't changed and the pool wasn't
+	// closed while the client was created. Otherwise the client is only returned to the waiting requests.
+	p.lock.Lock()
+	delete(p.calls, key)
+	if c.err == nil && p.credentials[cluster] == credentialsHash && !p.isClosed() {
+		p.entries[key] = &entry{
+			cluster:   cluster,
+			config:    c.config,
+			clientset: c.clientset,
+			lastUsed:  time.Now(),
+		}
+		metrics.Added()
+	}
+	p.lock.Unlock()
+	close(c.done)
+
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+
+	return rest.CopyConfig(c.config), c.clientset, nil
+}
+
+// isClosed returns true, when the pool was closed.
+func (p *Pool) isClosed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Invalidate removes all clients for the given cluster from the pool.
+func (p *Pool) Invalidate(cluster string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.deleteCluster(cluster, "invalidated")
+	delete(p.credentials, cluster)
+}
+
+// deleteCluster removes all clients for a cluster. The caller must hold the lock of the pool.
+func (p *Pool) deleteCluster(cluster, reason string) {
+	for key, e := range p.entries {
+		if e.cluster == cluster {
+			p.remove(key, reason)
+		}
+	}
+}
+
+// remove removes a single client from the pool and closes the idle connections of the client, so that they are not
+// kept open until they are closed by the server. The caller must hold the lock of the pool.
+func (p *Pool) remove(key, reason string) {
+	if e, ok := p.entries[key]; ok {
+		delete(p.entries, key)
+		metrics.Evicted(reason)
+		closeIdleConnections(e.clientset)
+	}
+}
+
+// evict runs in the background and removes all clients from the pool, which were not used for the configured ttl,
+// until the pool is closed.
+func (p *Pool) evict() {
+	ticker := time.NewTicker(p.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+
+		for key, e := range p.entries {
+			if time.Since(e.lastUsed) > p.ttl {
+				p.remove(key, "ttl")
+			}
+		}
+
+		for cluster := range p.credentials {
+			if !p.hasCluster(cluster) {
+				delete(p.credentials, cluster)
+			}
+		}
+
+		p.lock.Unlock()
+	}
+}
+
+// hasCluster returns true when the pool contains at least one client for the given cluster. The caller must hold the
+// lock of the pool.
+func (p *Pool) hasCluster(cluster string) bool {
+	for _, e := range p.entries {
+		if e.cluster == cluster {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closeIdleConnections closes the idle connections of the transport, which is used by the clientset. The transport is
+// wrapped by client-go, e.g. for the authentication, so that we have to unwrap it until we find a transport which can
+// close its idle connections.
+func closeIdleConnections(clientset *kubernetes.Clientset) {
+	if clientset == nil {
+		return
+	}
+
+	restClient, ok := clientset.CoreV1().RESTClient().(*rest.RESTClient)
+	if !ok || restClient.Client == nil {
+		return
+	}
+
+	rt := restClient.Client.Transport
+	for rt != nil {
+		if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+			return
+		}
+
+		wrapper, ok := rt.(utilnet.RoundTripperWrapper)
+		if !ok {
+			return
+		}
+		rt = wrapper.WrappedRoundTripper()
+	}
+}
+
+// CredentialsHash returns the hash of the given cluster and credentials, which is used by the pool to detect changed
+// credentials. It can also be used by other caches, which must be renewed when the credentials are changed.
+func CredentialsHash(cluster string, credentials []string) string {
+	return hash(append([]string{cluster}, credentials...))
+}
+
+// hash returns the sha256 hash for the given values. The length of each value is included, so that different values
+// can not result in the same input for the hash function.
+func hash(values []string) string {
+	h := sha256.New()
+	for _, value := range values {
+		fmt.Fprintf(h, "%d:%s;", len(value), value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package pool
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// getRequest is a single call of Pool.Get in a test case.
+type getRequest struct {
+	cluster     string
+	credentials []string
+	identity    []string
+	proxy       string
+	created     bool
+}
+
+func TestPoolGet(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		requests []getRequest
+	}{
+		{
+			name: "same request is cached",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"token"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, created: false},
+			},
+		},
+		{
+			name: "clusters are cached separately",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"token"}, created: true},
+				{cluster: "b", credentials: []string{"token"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, created: false},
+				{cluster: "b", credentials: []string{"token"}, created: false},
+			},
+		},
+		{
+			name: "changed credentials remove the clients of the cluster",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"token"}, created: true},
+				{cluster: "a", credentials: []string{"rotated"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, created: true},
+			},
+		},
+		{
+			name: "identities do not remove the clients of other identities",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"token"}, identity: []string{"alice"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, identity: []string{"bob"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, identity: []string{"alice"}, created: false},
+				{cluster: "a", credentials: []string{"token"}, identity: []string{"bob"}, created: false},
+			},
+		},
+		{
+			name: "proxy is part of the key",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"token"}, created: true},
+				{cluster: "a", credentials: []string{"token"}, proxy: "http://proxy:8080", created: true},
+				{cluster: "a", credentials: []string{"token"}, created: false},
+			},
+		},
+		{
+			name: "values are not concatenated",
+			requests: []getRequest{
+				{cluster: "a", credentials: []string{"ab", "c"}, created: true},
+				{cluster: "a", credentials: []string{"a", "bc"}, created: true},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(DefaultTTL)
+
+			for i, request := range tc.requests {
+				created := false
+				_, _, err := p.Get(request.cluster, request.credentials, request.identity, time.Minute, request.proxy, func() (*rest.Config, *kubernetes.Clientset, error) {
+					created = true
+					return &rest.Config{}, nil, nil
+				})
+				if err != nil {
+					t.Fatalf("request %d: expected no error, got %v", i, err)
+				}
+
+				if created != request.created {
+					t.Errorf("request %d: expected created %t, got %t", i, request.created, created)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolEvict(t *testing.T) {
+	p := New(50 * time.Millisecond)
+
+	get := func() bool {
+		created := false
+		p.Get("a", []string{"token"}, nil, time.Minute, "", func() (*rest.Config, *kubernetes.Clientset, error) {
+			created = true
+			return &rest.Config{}, nil, nil
+		})
+		return created
+	}
+
+	if !get() {
+		t.Fatalf("expected a new client")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	p.lock.Lock()
+	entries := len(p.entries)
+	_, hasCredentials := p.credentials["a"]
+	p.lock.Unlock()
+
+	if entries != 0 || hasCredentials {
+		t.Errorf("expected the unused client to be evicted, got %d entries", entries)
+	}
+
+	if !get() {
+		t.Errorf("expected a new client after the eviction")
+	}
+}
+
+func TestPoolInvalidate(t *testing.T) {
+	p := New(DefaultTTL)
+	create := func() (*rest.Config, *kubernetes.Clientset, error) {
+		return &rest.Config{}, nil, nil
+	}
+
+	p.Get("a", []string{"token"}, nil, time.Minute, "", create)
+	p.Get("b", []string{"token"}, nil, time.Minute, "", create)
+	p.Invalidate("a")
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.hasCluster("a") || !p.hasCluster("b") {
+		t.Errorf("expected only the clients of the invalidated cluster to be removed")
+	}
+}
+
+func TestPoolConcurrentGet(t *testing.T) {
+	p := New(DefaultTTL)
+
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slowCreate := func() (*rest.Config, *kubernetes.Clientset, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return &rest.Config{Host: "a"}, nil, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, _, err := p.Get("a", []string{"token"}, nil, time.Minute, "", slowCreate)
+			if err == nil && config.Host != "a" {
+				err = fmt.Errorf("expected the config of the created client, got %s", config.Host)
+			}
+			errs <- err
+		}()
+	}
+
+	<-started
+
+	// A slow creation of a client must not block the requests for other clients.
+	done := make(chan struct{})
+	go func() {
+		p.Get("b", []string{"token"}, nil, time.Minute, "", func() (*rest.Config, *kubernetes.Clientset, error) {
+			return &rest.Config{}, nil, nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the request for another cluster not to be blocked")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the client to be created once, got %d", calls)
+	}
+
+	p.lock.Lock()
+	entries := len(p.entries)
+	p.lock.Unlock()
+
+	if entries != 2 {
+		t.Errorf("expected 2 entries, got %d", entries)
+	}
+}
+
+// closeTransport is a transport for tests, which records if the idle connections were closed.
+type closeTransport struct {
+	closed bool
+}
+
+func (t *closeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (t *closeTransport) CloseIdleConnections() {
+	t.closed = true
+}
+
+func TestPoolClose(t *testing.T) {
+	transport := &closeTransport{}
+
+	p := New(DefaultTTL)
+	p.Get("a", []string{"token"}, nil, time.Minute, "", func() (*rest.Config, *kubernetes.Clientset, error) {
+		// The bearer token wraps the transport, so that the transport must be unwrapped to close the connections.
+		config := &rest.Config{Host: "https://127.0.0.1:6443", BearerToken: "token", Transport: transport}
+		clientset, err := kubernetes.NewForConfig(config)
+		return config, clientset, err
+	})
+
+	p.Close()
+	p.Close()
+
+	p.lock.Lock()
+	entries := len(p.entries)
+	p.lock.Unlock()
+
+	if entries != 0 {
+		t.Errorf("expected all clients to be removed, got %d entries", entries)
+	}
+
+	if !transport.closed {
+		t.Errorf("expected the idle connections of the removed client to be closed")
+	}
+}
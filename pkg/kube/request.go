@@ -4,26 +4,68 @@ import (
 	"context"
 	"fmt"
 
+	kubetypes "github.com/kubenav/kubenav/pkg/kube/types"
+
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
+// DefaultFieldManager is the name of the field manager, which is used for server-side apply requests when the frontend
+// doesn't provide a field manager.
+const DefaultFieldManager = "kubenav"
+
 // Request is the structure of an API request to interact with the Kubernetes API. Most of the fields are used for the
 // mobile version of kubenav, because the cluster data is only accessible via the frontend.
 type Request struct {
-	Cluster                  string `json:"cluster"`
-	Method                   string `json:"method"`
-	URL                      string `json:"url"`
-	Body                     string `json:"body"`
-	CertificateAuthorityData string `json:"certificateAuthorityData"`
-	ClientCertificateData    string `json:"clientCertificateData"`
-	ClientKeyData            string `json:"clientKeyData"`
-	Token                    string `json:"token"`
-	Username                 string `json:"username"`
-	Password                 string `json:"password"`
-	InsecureSkipTLSVerify    bool   `json:"insecureSkipTLSVerify"`
-	Timeout                  int64  `json:"timeout"`
-	Proxy                    string `json:"proxy"`
+	Cluster                  string                  `json:"cluster"`
+	Method                   string                  `json:"method"`
+	URL                      string                  `json:"url"`
+	Body                     string                  `json:"body"`
+	PatchType                string                  `json:"patchType"`
+	FieldManager             string                  `json:"fieldManager"`
+	Force                    bool                    `json:"force"`
+	CertificateAuthorityData string                  `json:"certificateAuthorityData"`
+	ClientCertificateData    string                  `json:"clientCertificateData"`
+	ClientKeyData            string                  `json:"clientKeyData"`
+	Token                    string                  `json:"token"`
+	Username                 string                  `json:"username"`
+	Password                 string                  `json:"password"`
+	InsecureSkipTLSVerify    bool                    `json:"insecureSkipTLSVerify"`
+	Timeout                  int64                   `json:"timeout"`
+	Proxy                    string                  `json:"proxy"`
+	TLSServerName            string                  `json:"tlsServerName"`
+	AuthProvider             *kubetypes.AuthProvider `json:"authProviderConfig"`
+	ImpersonateUser          string                  `json:"impersonateUser"`
+	ImpersonateGroups        []string                `json:"impersonateGroups"`
+	ImpersonateExtra         map[string][]string     `json:"impersonateExtra"`
+}
+
+// Credentials returns the credentials from the request, which are used to create the Kubernetes API client via the
+// GetConfigAndClientset method of the kube client.
+// NOTE: The impersonation fields are returned as they are. The caller must check if impersonation is allowed.
+func (r Request) Credentials() kubetypes.ClusterCredentials {
+	return kubetypes.ClusterCredentials{
+		Cluster:                  r.Cluster,
+		Server:                   r.URL,
+		CertificateAuthorityData: r.CertificateAuthorityData,
+		ClientCertificateData:    r.ClientCertificateData,
+		ClientKeyData:            r.ClientKeyData,
+		Token:                    r.Token,
+		Username:                 r.Username,
+		Password:                 r.Password,
+		InsecureSkipTLSVerify:    r.InsecureSkipTLSVerify,
+		TLSServerName:            r.TLSServerName,
+		AuthProvider:             r.AuthProvider,
+		ImpersonateUser:          r.ImpersonateUser,
+		ImpersonateGroups:        r.ImpersonateGroups,
+		ImpersonateExtra:         r.ImpersonateExtra,
+		Proxy:                    r.Proxy,
+	}
+}
+
+// IsImpersonated returns true when the request should be made as another user or group.
+func (r Request) IsImpersonated() bool {
+	return r.ImpersonateUser != "" || len(r.ImpersonateGroups) > 0 || len(r.ImpersonateExtra) > 0
 }
 
 // Response is the structure, which is used to return the data from an API request against the Kubernetes API server to
@@ -45,16 +87,53 @@ type Error struct {
 // KubernetesRequest makes the request to the Kubernetes API server. A request contains a method, url, body and timeout.
 // The API server data is defined in the clientset, which can be retrieved via the GetConfigAndClientset method of the
 // kube client.
-func KubernetesRequest(ctx context.Context, method, url, body string, clientset *kubernetes.Clientset) ([]byte, error) {
-	if method == "GET" {
-		return clientset.RESTClient().Get().RequestURI(url).DoRaw(ctx)
-	} else if method == "DELETE" {
-		return clientset.RESTClient().Delete().RequestURI(url).Body([]byte(body)).DoRaw(ctx)
-	} else if method == "PATCH" {
-		return clientset.RESTClient().Patch(types.JSONPatchType).RequestURI(url).Body([]byte(body)).DoRaw(ctx)
-	} else if method == "POST" {
-		return clientset.RESTClient().Post().RequestURI(url).Body([]byte(body)).DoRaw(ctx)
+// For PATCH requests the patch type can be set via the patchType field of the request. If the patch type isn't set we
+// are using a JSON patch. For server-side apply requests the fieldManager and force fields are passed to the API server.
+func KubernetesRequest(ctx context.Context, request Request, clientset *kubernetes.Clientset) ([]byte, error) {
+	if request.Method == "GET" {
+		return clientset.RESTClient().Get().RequestURI(request.URL).DoRaw(ctx)
+	} else if request.Method == "DELETE" {
+		return clientset.RESTClient().Delete().RequestURI(request.URL).Body([]byte(request.Body)).DoRaw(ctx)
+	} else if request.Method == "PATCH" {
+		patchType, err := getPatchType(request.PatchType)
+		if err != nil {
+			return []byte(``), err
+		}
+
+		req := clientset.RESTClient().Patch(patchType).RequestURI(request.URL).Body([]byte(request.Body))
+		if patchType == types.ApplyPatchType {
+			fieldManager := request.FieldManager
+			if fieldManager == "" {
+				fieldManager = DefaultFieldManager
+			}
+
+			req = req.Param("fieldManager", fieldManager)
+			if request.Force {
+				req = req.Param("force", "true")
+			}
+		} else if request.FieldManager != "" {
+			req = req.Param("fieldManager", request.FieldManager)
+		}
+
+		return req.DoRaw(ctx)
+	} else if request.Method == "POST" {
+		return clientset.RESTClient().Post().RequestURI(request.URL).Body([]byte(request.Body)).DoRaw(ctx)
+	} else if request.Method == "PUT" {
+		return clientset.RESTClient().Put().RequestURI(request.URL).Body([]byte(request.Body)).DoRaw(ctx)
 	}
 
 	return []byte(``), fmt.Errorf("Request method is not implemented")
 }
+
+// getPatchType returns the patch type for the given content type. When no content type is provided the JSON patch type
+// is returned, so that the existing requests from the frontend are working as before.
+func getPatchType(patchType string) (types.PatchType, error) {
+	switch types.PatchType(patchType) {
+	case "", types.JSONPatchType:
+		return types.JSONPatchType, nil
+	case types.MergePatchType, types.StrategicMergePatchType, types.ApplyPatchType:
+		return types.PatchType(patchType), nil
+	}
+
+	return "", fmt.Errorf("Patch type is not supported")
+}
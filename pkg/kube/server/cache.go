@@ -0,0 +1,350 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube/pool"
+	"github.com/kubenav/kubenav/pkg/kube/types"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+)
+
+// cachedResource is the structure of a resource, which is served from the cache. Next to the group, version and
+// resource we need the kind of the resource to build the list responses.
+type cachedResource struct {
+	GVR        schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+}
+
+// cachedResources is the list of resources, which are served from the cache. We only cache the most common resources,
+// which are shown in the frontend. Secrets are not cached, because we do not want to hold them in memory.
+var cachedResources = []cachedResource{
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}, Kind: "Pod", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "services"}, Kind: "Service", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}, Kind: "ConfigMap", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "persistentvolumeclaims"}, Kind: "PersistentVolumeClaim", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "persistentvolumes"}, Kind: "PersistentVolume", Namespaced: false},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}, Kind: "Node", Namespaced: false},
+	{GVR: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}, Kind: "Namespace", Namespaced: false},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, Kind: "StatefulSet", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, Kind: "DaemonSet", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, Kind: "ReplicaSet", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, Kind: "Job", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, Kind: "Ingress", Namespaced: true},
+}
+
+// cacheTTL is the time after which the informers of an unused context are stopped.
+const cacheTTL = 30 * time.Minute
+
+// cache holds the informers for all contexts. The informers for a context are created, when the first request for
+// this context is made. They are stopped, when the credentials of the context are changed or when the context wasn't
+// used for the cacheTTL.
+type cache struct {
+	contexts map[string]*contextCache
+	lock     sync.Mutex
+}
+
+// contextCache holds the informer factory for a single context and the channel to stop all informers of the context.
+// The credentials are the hash of the credentials, which were used to create the informers (see pool.CredentialsHash).
+type contextCache struct {
+	host        string
+	credentials string
+	factory     dynamicinformer.DynamicSharedInformerFactory
+	stopCh      chan struct{}
+	lastUsed    time.Time
+}
+
+// cacheRequest is the parsed structure of a request URL, which can be answered from the cache.
+type cacheRequest struct {
+	resource  cachedResource
+	namespace string
+	name      string
+	selector  labels.Selector
+}
+
+// newCache returns a new cache without any informers.
+func newCache() *cache {
+	return &cache{
+		contexts: make(map[string]*contextCache),
+	}
+}
+
+// get returns the informers for the given context. If the informers for the context doesn't exist yet or the
+// credentials of the context were changed, we create a new informer factory for all cached resources and start it.
+func (c *cache) get(context string, config *rest.Config) (*contextCache, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.evict()
+
+	credentialsHash := pool.CredentialsHash(context, credentials(config))
+
+	if cc, ok := c.contexts[context]; ok {
+		if cc.credentials == credentialsHash {
+			cc.lastUsed = time.Now()
+			return cc, nil
+		}
+
+		log.WithFields(log.Fields{"context": context}).Infof("Credentials were changed, stop informers for cache")
+		c.delete(context)
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	for _, resource := range cachedResources {
+		factory.ForResource(resource.GVR).Informer()
+	}
+
+	cc := &contextCache{
+		host:        config.Host,
+		credentials: credentialsHash,
+		factory:     factory,
+		stopCh:      make(chan struct{}),
+		lastUsed:    time.Now(),
+	}
+
+	log.WithFields(log.Fields{"context": context}).Infof("Start informers for cache")
+	factory.Start(cc.stopCh)
+	c.contexts[context] = cc
+
+	return cc, nil
+}
+
+// evict stops the informers of all contexts, which were not used for the cacheTTL. The caller must hold the lock of
+// the cache.
+func (c *cache) evict() {
+	for context, cc := range c.contexts {
+		if time.Since(cc.lastUsed) > cacheTTL {
+			log.WithFields(log.Fields{"context": context}).Infof("Stop informers for unused cache")
+			c.delete(context)
+		}
+	}
+}
+
+// delete stops the informers of the given context and removes the context from the cache. The caller must hold the
+// lock of the cache.
+func (c *cache) delete(context string) {
+	if cc, ok := c.contexts[context]; ok {
+		close(cc.stopCh)
+		delete(c.contexts, context)
+	}
+}
+
+// close stops the informers of all contexts.
+func (c *cache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for context := range c.contexts {
+		c.delete(context)
+	}
+}
+
+// status returns the sync state of all informers for all contexts.
+func (c *cache) status() map[string]types.CacheStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	status := make(map[string]types.CacheStatus)
+	for context, cc := range c.contexts {
+		contextStatus := types.CacheStatus{
+			Context:   context,
+			Synced:    true,
+			Resources: make(map[string]bool),
+		}
+
+		for _, resource := range cachedResources {
+			synced := cc.factory.ForResource(resource.GVR).Informer().HasSynced()
+			contextStatus.Resources[resource.GVR.String()] = synced
+			if !synced {
+				contextStatus.Synced = false
+			}
+		}
+
+		status[context] = contextStatus
+	}
+
+	return status
+}
+
+// request answers the given request URL from the cache. If the request can not be answered from the cache, because the
+// resource isn't cached, the informer isn't synced yet or the request contains unsupported parameters, the second
+// return value is false and the caller has to make the request against the Kubernetes API server.
+func (cc *contextCache) request(requestURL string) ([]byte, bool, error) {
+	req, ok := cc.parse(requestURL)
+	if !ok {
+		return nil, false, nil
+	}
+
+	informer := cc.factory.ForResource(req.resource.GVR)
+	if !informer.Informer().HasSynced() {
+		return nil, false, nil
+	}
+
+	lister := informer.Lister()
+	resourceVersion := informer.Informer().LastSyncResourceVersion()
+
+	if req.name != "" {
+		var obj runtime.Object
+		var err error
+
+		if req.resource.Namespaced {
+			obj, err = lister.ByNamespace(req.namespace).Get(req.name)
+		} else {
+			obj, err = lister.Get(req.name)
+		}
+
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return data, true, nil
+	}
+
+	var objs []runtime.Object
+	var err error
+
+	if req.resource.Namespaced && req.namespace != "" {
+		objs, err = lister.ByNamespace(req.namespace).List(req.selector)
+	} else {
+		objs, err = lister.List(req.selector)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	list := &unstructured.UnstructuredList{
+		Object: map[string]interface{}{
+			"apiVersion": req.resource.GVR.GroupVersion().String(),
+			"kind":       req.resource.Kind + "List",
+			"metadata":   map[string]interface{}{"resourceVersion": resourceVersion},
+		},
+	}
+
+	for _, obj := range objs {
+		if item, ok := obj.(*unstructured.Unstructured); ok {
+			list.Items = append(list.Items, *item)
+		}
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		if list.Items[i].GetNamespace() != list.Items[j].GetNamespace() {
+			return list.Items[i].GetNamespace() < list.Items[j].GetNamespace()
+		}
+		return list.Items[i].GetName() < list.Items[j].GetName()
+	})
+
+	data, err := list.MarshalJSON()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+// parse parses the request URL and returns the resource, namespace, name and label selector of the request. We only
+// support list and get requests for the cached resources, which can contain a label selector. All other requests (e.g.
+// requests for subresources or with a field selector) are not handled by the cache.
+func (cc *contextCache) parse(requestURL string) (cacheRequest, bool) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return cacheRequest{}, false
+	}
+
+	var selector labels.Selector = labels.Everything()
+	for key, values := range u.Query() {
+		if key != "labelSelector" || len(values) != 1 {
+			return cacheRequest{}, false
+		}
+
+		selector, err = labels.Parse(values[0])
+		if err != nil {
+			return cacheRequest{}, false
+		}
+	}
+
+	path := u.Path
+	if host, err := url.Parse(cc.host); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(host.Path, "/"))
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var gv schema.GroupVersion
+	if len(segments) >= 2 && segments[0] == "api" {
+		gv = schema.GroupVersion{Group: "", Version: segments[1]}
+		segments = segments[2:]
+	} else if len(segments) >= 3 && segments[0] == "apis" {
+		gv = schema.GroupVersion{Group: segments[1], Version: segments[2]}
+		segments = segments[3:]
+	} else {
+		return cacheRequest{}, false
+	}
+
+	var namespace, resource, name string
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		namespace = segments[1]
+		segments = segments[2:]
+	}
+
+	switch len(segments) {
+	case 1:
+		resource = segments[0]
+	case 2:
+		resource = segments[0]
+		name = segments[1]
+	default:
+		return cacheRequest{}, false
+	}
+
+	for _, r := range cachedResources {
+		if r.GVR.GroupVersion() == gv && r.GVR.Resource == resource {
+			if !r.Namespaced && namespace != "" {
+				return cacheRequest{}, false
+			}
+			if r.Namespaced && name != "" && namespace == "" {
+				return cacheRequest{}, false
+			}
+
+			return cacheRequest{
+				resource:  r,
+				namespace: namespace,
+				name:      name,
+				selector:  selector,
+			}, true
+		}
+	}
+
+	return cacheRequest{}, false
+}
+
+// errCacheDisabled is returned when the cache is used, but it wasn't enabled via the command-line flag.
+var errCacheDisabled = fmt.Errorf("Cache is not enabled")
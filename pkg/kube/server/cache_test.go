@@ -0,0 +1,107 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestContextCacheParse(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		host              string
+		requestURL        string
+		ok                bool
+		expectedResource  string
+		expectedNamespace string
+		expectedName      string
+		expectedSelector  string
+	}{
+		{name: "list pods in all namespaces", host: "https://cluster", requestURL: "https://cluster/api/v1/pods", ok: true, expectedResource: "pods"},
+		{name: "list pods in namespace", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/pods", ok: true, expectedResource: "pods", expectedNamespace: "default"},
+		{name: "get pod", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/pods/nginx", ok: true, expectedResource: "pods", expectedNamespace: "default", expectedName: "nginx"},
+		{name: "list deployments", host: "https://cluster", requestURL: "https://cluster/apis/apps/v1/namespaces/default/deployments", ok: true, expectedResource: "deployments", expectedNamespace: "default"},
+		{name: "get node", host: "https://cluster", requestURL: "https://cluster/api/v1/nodes/node-1", ok: true, expectedResource: "nodes", expectedName: "node-1"},
+		{name: "get namespace", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default", ok: true, expectedResource: "namespaces", expectedName: "default"},
+		{name: "label selector", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/pods?labelSelector=app%3Dnginx", ok: true, expectedResource: "pods", expectedNamespace: "default", expectedSelector: "app=nginx"},
+		{name: "host with path prefix", host: "https://rancher/k8s/clusters/c-1", requestURL: "https://rancher/k8s/clusters/c-1/api/v1/namespaces/default/pods", ok: true, expectedResource: "pods", expectedNamespace: "default"},
+		{name: "relative url", host: "https://cluster", requestURL: "/api/v1/namespaces/default/pods", ok: true, expectedResource: "pods", expectedNamespace: "default"},
+		{name: "field selector", host: "https://cluster", requestURL: "https://cluster/api/v1/pods?fieldSelector=spec.nodeName%3Dnode-1", ok: false},
+		{name: "multiple label selectors", host: "https://cluster", requestURL: "https://cluster/api/v1/pods?labelSelector=a%3Db&labelSelector=c%3Dd", ok: false},
+		{name: "invalid label selector", host: "https://cluster", requestURL: "https://cluster/api/v1/pods?labelSelector=%21%21", ok: false},
+		{name: "subresource", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/pods/nginx/log", ok: false},
+		{name: "secrets are not cached", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/secrets", ok: false},
+		{name: "wrong version", host: "https://cluster", requestURL: "https://cluster/apis/apps/v1beta1/namespaces/default/deployments", ok: false},
+		{name: "cluster scoped resource in namespace", host: "https://cluster", requestURL: "https://cluster/api/v1/namespaces/default/nodes", ok: false},
+		{name: "namespaced resource by name without namespace", host: "https://cluster", requestURL: "https://cluster/api/v1/pods/nginx", ok: false},
+		{name: "unknown api", host: "https://cluster", requestURL: "https://cluster/version", ok: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cc := &contextCache{host: tc.host}
+
+			req, ok := cc.parse(tc.requestURL)
+			if ok != tc.ok {
+				t.Fatalf("expected ok %t, got %t", tc.ok, ok)
+			}
+			if !ok {
+				return
+			}
+
+			if req.resource.GVR.Resource != tc.expectedResource || req.namespace != tc.expectedNamespace || req.name != tc.expectedName {
+				t.Errorf("expected %s %s/%s, got %s %s/%s", tc.expectedResource, tc.expectedNamespace, tc.expectedName, req.resource.GVR.Resource, req.namespace, req.name)
+			}
+
+			if req.selector.String() != tc.expectedSelector {
+				t.Errorf("expected selector %q, got %q", tc.expectedSelector, req.selector.String())
+			}
+		})
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	c := newCache()
+	config := &rest.Config{Host: "https://127.0.0.1:1", BearerToken: "first"}
+
+	first, err := c.get("kind", config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	same, err := c.get("kind", config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if same != first {
+		t.Errorf("expected the cached informers for unchanged credentials")
+	}
+
+	renewed, err := c.get("kind", &rest.Config{Host: "https://127.0.0.1:1", BearerToken: "second"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if renewed == first {
+		t.Errorf("expected new informers for changed credentials")
+	}
+
+	select {
+	case <-first.stopCh:
+	default:
+		t.Errorf("expected the informers for the old credentials to be stopped")
+	}
+
+	renewed.lastUsed = time.Now().Add(-2 * cacheTTL)
+	c.lock.Lock()
+	c.evict()
+	c.lock.Unlock()
+
+	if _, ok := c.contexts["kind"]; ok {
+		t.Errorf("expected the unused informers to be removed")
+	}
+
+	select {
+	case <-renewed.stopCh:
+	default:
+		t.Errorf("expected the unused informers to be stopped")
+	}
+}
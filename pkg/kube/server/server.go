@@ -2,10 +2,14 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/kubenav/kubenav/pkg/kube/pool"
 	"github.com/kubenav/kubenav/pkg/kube/types"
 
 	"k8s.io/client-go/kubernetes"
@@ -16,13 +20,16 @@ import (
 // Client implements an API client for the Kubernetes API.
 type Client struct {
 	config clientcmd.ClientConfig
+	cache  *cache
+	pool   *pool.Pool
 }
 
 // NewClient returns a new API client for Kubernetes.
 // When the incluster option is true, we are using the in cluster configuration for the client, when a slice of
 // Kubeconfig files is provided which should be included/excluded we are loading these files. By default we are using
 // the standard way to load the cluster configuration.
-func NewClient(incluster bool, kubeconfig, kubeconfigInclude, kubeconfigExclude string) (*Client, error) {
+// When the cache option is true, list and get requests for the most common resources are served from an informer cache.
+func NewClient(incluster bool, kubeconfig, kubeconfigInclude, kubeconfigExclude string, cache bool) (*Client, error) {
 	var config clientcmd.ClientConfig
 	var err error
 
@@ -43,9 +50,16 @@ func NewClient(incluster bool, kubeconfig, kubeconfigInclude, kubeconfigExclude
 		}
 	}
 
-	return &Client{
+	client := &Client{
 		config: config,
-	}, nil
+		pool:   pool.New(pool.DefaultTTL),
+	}
+
+	if cache {
+		client.cache = newCache()
+	}
+
+	return client, nil
 }
 
 // Cluster returns the current context from the loaded Kubeconfig.
@@ -115,37 +129,167 @@ func (c *Client) ChangeNamespace(context, namespace string) error {
 }
 
 // GetConfigAndClientset returns an rest client and the clientset to interact with a Kubernetes cluster.
-// The server and desktop implementation mainly uses the "cluster" argument, because only need to select the current
-// cluster (for kubenav this is the same like the context) to interact with.
-func (c *Client) GetConfigAndClientset(cluster, server, certificateAuthorityData, clientCertificateData, clientKeyData, token, username, password string, insecureSkipTLSVerify bool, timeout time.Duration, proxy string) (*rest.Config, *kubernetes.Clientset, error) {
-	raw, err := c.config.RawConfig()
-	if err != nil {
+// The server and desktop implementation mainly uses the "cluster" field of the credentials, because only need to select
+// the current cluster (for kubenav this is the same like the context) to interact with. Additionally the proxy and the
+// impersonation fields are applied to the configuration of the selected cluster.
+// The clients are cached in a pool. Since the Kubeconfig file can be changed while kubenav is running, we load the rest
+// config for the cluster on every call and use the credentials from the rest config as key for the pool.
+func (c *Client) GetConfigAndClientset(clusterCredentials types.ClusterCredentials, timeout time.Duration) (*rest.Config, *kubernetes.Clientset, error) {
+	if err := clusterCredentials.ValidateImpersonation(); err != nil {
 		return nil, nil, err
 	}
 
-	override := &clientcmd.ConfigOverrides{CurrentContext: cluster}
-	currentContextConfig := clientcmd.NewNonInteractiveClientConfig(raw, override.CurrentContext, override, &clientcmd.ClientConfigLoadingRules{})
-
-	restClient, err := currentContextConfig.ClientConfig()
+	restClient, err := c.restConfig(clusterCredentials.Cluster)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	restClient.Timeout = timeout
+	if clusterCredentials.IsImpersonated() {
+		restClient.Impersonate = rest.ImpersonationConfig{
+			UserName: clusterCredentials.ImpersonateUser,
+			Groups:   clusterCredentials.ImpersonateGroups,
+			Extra:    clusterCredentials.ImpersonateExtra,
+		}
+	}
+
+	return c.pool.Get(clusterCredentials.Cluster, credentials(restClient), identity(restClient), timeout, clusterCredentials.Proxy, func() (*rest.Config, *kubernetes.Clientset, error) {
+		restClient.Timeout = timeout
+
+		if clusterCredentials.Proxy != "" {
+			proxyURL, err := url.Parse(clusterCredentials.Proxy)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			restClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		}
 
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
+		clientset, err := kubernetes.NewForConfig(restClient)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		restClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		return restClient, clientset, nil
+	})
+}
+
+// CachedRequest returns the result for a GET request from the informer cache of the given cluster. If the request can
+// not be answered from the cache, the second return value is false and the request must be sent to the Kubernetes API
+// server. When the cache wasn't enabled, we never answer a request from the cache.
+func (c *Client) CachedRequest(cluster, url string) ([]byte, bool, error) {
+	if c.cache == nil {
+		return nil, false, nil
 	}
 
-	clientset, err := kubernetes.NewForConfig(restClient)
+	config, err := c.restConfig(cluster)
 	if err != nil {
-		return nil, nil, err
+		return nil, false, err
+	}
+
+	cc, err := c.cache.get(cluster, config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return cc.request(url)
+}
+
+// CacheStatus returns the sync state of the informer cache for all clusters, which were requested since the start of
+// kubenav.
+func (c *Client) CacheStatus() (map[string]types.CacheStatus, error) {
+	if c.cache == nil {
+		return nil, errCacheDisabled
+	}
+
+	return c.cache.status(), nil
+}
+
+// Close stops the informers of the cache and removes all clients from the pool.
+func (c *Client) Close() {
+	if c.cache != nil {
+		c.cache.close()
+	}
+
+	c.pool.Close()
+}
+
+// restConfig returns the rest config for the given cluster from the loaded Kubeconfig.
+func (c *Client) restConfig(cluster string) (*rest.Config, error) {
+	raw, err := c.config.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	override := &clientcmd.ConfigOverrides{CurrentContext: cluster}
+	currentContextConfig := clientcmd.NewNonInteractiveClientConfig(raw, override.CurrentContext, override, &clientcmd.ClientConfigLoadingRules{})
+
+	return currentContextConfig.ClientConfig()
+}
+
+// credentials returns all values of the rest config, which are used to authenticate against the Kubernetes API server.
+// The values are used as key for the client pool, so that a new client is created when the credentials in the
+// Kubeconfig file are changed. The impersonation fields are returned by identity.
+func credentials(config *rest.Config) []string {
+	values := []string{
+		config.Host,
+		config.APIPath,
+		config.Username,
+		config.Password,
+		config.BearerToken,
+		config.BearerTokenFile,
+		config.TLSClientConfig.ServerName,
+		config.TLSClientConfig.CertFile,
+		config.TLSClientConfig.KeyFile,
+		config.TLSClientConfig.CAFile,
+		string(config.TLSClientConfig.CertData),
+		string(config.TLSClientConfig.KeyData),
+		string(config.TLSClientConfig.CAData),
+		fmt.Sprintf("%t", config.TLSClientConfig.Insecure),
+	}
+
+	if config.AuthProvider != nil {
+		values = append(values, config.AuthProvider.Name)
+
+		var keys []string
+		for key := range config.AuthProvider.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			values = append(values, key, config.AuthProvider.Config[key])
+		}
+	}
+
+	if config.ExecProvider != nil {
+		values = append(values, config.ExecProvider.Command)
+		values = append(values, config.ExecProvider.Args...)
+
+		for _, env := range config.ExecProvider.Env {
+			values = append(values, env.Name, env.Value)
+		}
+	}
+
+	return values
+}
+
+// identity returns all values of the rest config, which are used to impersonate another user. The values are only used
+// in the key for the client pool, so that a change of the impersonated user doesn't remove the clients of other users.
+func identity(config *rest.Config) []string {
+	values := []string{
+		config.Impersonate.UserName,
+		strings.Join(config.Impersonate.Groups, ","),
+	}
+
+	var extraKeys []string
+	for key := range config.Impersonate.Extra {
+		extraKeys = append(extraKeys, key)
+	}
+	sort.Strings(extraKeys)
+
+	for _, key := range extraKeys {
+		values = append(values, key, strings.Join(config.Impersonate.Extra[key], ","))
 	}
 
-	return restClient, clientset, nil
+	return values
 }
@@ -1,6 +1,10 @@
 // Package types hold the structs which are shared betweem the different implementations of the Kubernetes API client.
 package types
 
+import (
+	"fmt"
+)
+
 // Cluster implements the cluster type used in the React app.
 // This is only needed for the server and Electron implementation. The clusters for the mobile version are saved and
 // accessed via the frontend.
@@ -17,3 +21,55 @@ type Cluster struct {
 	AuthProvider             string `json:"authProvider"`
 	Namespace                string `json:"namespace"`
 }
+
+// CacheStatus implements the sync state of the informer cache for a single context.
+// This is only needed for the server and Electron implementation, when the cache is enabled via the command-line flag.
+type CacheStatus struct {
+	Context   string          `json:"context"`
+	Synced    bool            `json:"synced"`
+	Resources map[string]bool `json:"resources"`
+}
+
+// ClusterCredentials implements the structure, which is passed to the GetConfigAndClientset method of the Kubernetes
+// API client. The server and desktop implementation mainly uses the cluster field to select the context from the
+// Kubeconfig file, while the mobile implementation uses all other fields to create the configuration for the cluster.
+// Because the fields are sent with every request, they must not contain exec plugins or paths to local files, which
+// would allow every caller of the API to run commands or to read files on the device.
+type ClusterCredentials struct {
+	Cluster                  string              `json:"cluster"`
+	Server                   string              `json:"server"`
+	CertificateAuthorityData string              `json:"certificateAuthorityData"`
+	ClientCertificateData    string              `json:"clientCertificateData"`
+	ClientKeyData            string              `json:"clientKeyData"`
+	Token                    string              `json:"token"`
+	Username                 string              `json:"username"`
+	Password                 string              `json:"password"`
+	InsecureSkipTLSVerify    bool                `json:"insecureSkipTLSVerify"`
+	TLSServerName            string              `json:"tlsServerName"`
+	AuthProvider             *AuthProvider       `json:"authProvider"`
+	ImpersonateUser          string              `json:"impersonateUser"`
+	ImpersonateGroups        []string            `json:"impersonateGroups"`
+	ImpersonateExtra         map[string][]string `json:"impersonateExtra"`
+	Proxy                    string              `json:"proxy"`
+}
+
+// IsImpersonated returns true, when any of the impersonation fields is set.
+func (c ClusterCredentials) IsImpersonated() bool {
+	return c.ImpersonateUser != "" || len(c.ImpersonateGroups) > 0 || len(c.ImpersonateExtra) > 0
+}
+
+// ValidateImpersonation returns an error, when groups or extra fields should be impersonated without a user. Such a
+// request would otherwise be sent with the identity of kubenav instead of the requested identity.
+func (c ClusterCredentials) ValidateImpersonation() error {
+	if c.ImpersonateUser == "" && c.IsImpersonated() {
+		return fmt.Errorf("impersonation of groups or extra fields requires a user")
+	}
+
+	return nil
+}
+
+// AuthProvider implements the auth-provider section of a Kubeconfig file.
+type AuthProvider struct {
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config"`
+}
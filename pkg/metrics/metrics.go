@@ -0,0 +1,80 @@
+// Package metrics implements the Prometheus metrics of kubenav. The metrics are only registered by the server and
+// desktop implementation of kubenav, so that Prometheus isn't part of the mobile build.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/kubenav/kubenav/pkg/kube/pool"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// poolMetrics implements the pool.Metrics interface for the hit rate, the evictions and the number of clients of the
+// Kubernetes API client pools.
+type poolMetrics struct {
+	hitsTotal      prometheus.Counter
+	missesTotal    prometheus.Counter
+	evictionsTotal *prometheus.CounterVec
+	clients        prometheus.Gauge
+}
+
+func (m *poolMetrics) Hit()   { m.hitsTotal.Inc() }
+func (m *poolMetrics) Miss()  { m.missesTotal.Inc() }
+func (m *poolMetrics) Added() { m.clients.Inc() }
+
+func (m *poolMetrics) Evicted(reason string) {
+	m.evictionsTotal.WithLabelValues(reason).Inc()
+	m.clients.Dec()
+}
+
+// Register registers all metrics of kubenav with the default registerer and sets the Metrics implementation of the
+// client pool.
+func Register() error {
+	return register(prometheus.DefaultRegisterer)
+}
+
+// register registers all metrics of kubenav with the given registerer.
+func register(registerer prometheus.Registerer) error {
+	m := &poolMetrics{
+		hitsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kubenav",
+			Subsystem: "client_pool",
+			Name:      "hits_total",
+			Help:      "Number of requests for a Kubernetes API client, which were served from the pool.",
+		}),
+		missesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kubenav",
+			Subsystem: "client_pool",
+			Name:      "misses_total",
+			Help:      "Number of requests for a Kubernetes API client, which required a new client.",
+		}),
+		evictionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kubenav",
+			Subsystem: "client_pool",
+			Name:      "evictions_total",
+			Help:      "Number of clients, which were removed from the pool.",
+		}, []string{"reason"}),
+		clients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kubenav",
+			Subsystem: "client_pool",
+			Name:      "clients",
+			Help:      "Number of clients in the pool.",
+		}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.hitsTotal, m.missesTotal, m.evictionsTotal, m.clients} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	pool.SetMetrics(m)
+	return nil
+}
+
+// Handler returns the HTTP handler, which exposes the metrics registered with the default registerer.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
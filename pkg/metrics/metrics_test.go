@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube/pool"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestRegister(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if err := register(registry); err != nil {
+		t.Fatalf("could not register metrics: %v", err)
+	}
+	defer pool.SetMetrics(nil)
+
+	create := func() (*rest.Config, *kubernetes.Clientset, error) {
+		return &rest.Config{}, &kubernetes.Clientset{}, nil
+	}
+
+	p := pool.New(time.Hour)
+	p.Get("a", []string{"token"}, nil, 0, "", create)
+	p.Get("a", []string{"token"}, nil, 0, "", create)
+	p.Get("b", []string{"token"}, nil, 0, "", create)
+	p.Invalidate("a")
+
+	expected := `
+# HELP kubenav_client_pool_clients Number of clients in the pool.
+# TYPE kubenav_client_pool_clients gauge
+kubenav_client_pool_clients 1
+# HELP kubenav_client_pool_evictions_total Number of clients, which were removed from the pool.
+# TYPE kubenav_client_pool_evictions_total counter
+kubenav_client_pool_evictions_total{reason="invalidated"} 1
+# HELP kubenav_client_pool_hits_total Number of requests for a Kubernetes API client, which were served from the pool.
+# TYPE kubenav_client_pool_hits_total counter
+kubenav_client_pool_hits_total 1
+# HELP kubenav_client_pool_misses_total Number of requests for a Kubernetes API client, which required a new client.
+# TYPE kubenav_client_pool_misses_total counter
+kubenav_client_pool_misses_total 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+
+	if err := register(registry); err == nil {
+		t.Errorf("expected an error when the metrics are registered twice")
+	}
+}
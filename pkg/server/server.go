@@ -0,0 +1,296 @@
+// Package server implements the HTTP server for the server, mobile and desktop implementation of kubenav. The server
+// can be configured with a listen address, TLS certificates (which are reloaded when the files are changed), mTLS
+// client verification and timeouts. While the server is running, expired terminal, log and watch sessions are removed in the
+// background. When the passed context is canceled, the server is shut down gracefully and all active terminal, log and
+// port forwarding sessions are closed.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/handlers/portforwarding"
+	"github.com/kubenav/kubenav/pkg/handlers/terminal"
+	"github.com/kubenav/kubenav/pkg/handlers/watch"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// reapInterval is the interval in which expired sessions are removed.
+	reapInterval = 10 * time.Second
+	// failedPortForwardingTimeout is the time after which failed port forwarding sessions are removed.
+	failedPortForwardingTimeout = 5 * time.Minute
+)
+
+// Config is the configuration for the HTTP server. When the TLS certificate and key files are empty, the server is
+// started without TLS. When the client CA file is set, all clients must present a certificate signed by this CA.
+// The read and write timeouts should be 0 or large enough for long running requests, like the streaming of logs.
+type Config struct {
+	Address          string
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSClientCAFile  string
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	IdleTimeout      time.Duration
+	ShutdownTimeout  time.Duration
+	CertReloadPeriod time.Duration
+}
+
+// DefaultConfig is the configuration, which is used by the mobile implementation of kubenav. The defaults for the
+// server implementation are set via command-line flags.
+var DefaultConfig = Config{
+	Address:          ":14122",
+	IdleTimeout:      120 * time.Second,
+	ShutdownTimeout:  10 * time.Second,
+	CertReloadPeriod: 30 * time.Second,
+}
+
+// Run starts the HTTP server with the given handler and blocks until the server is stopped. When the context is
+// canceled, the server stops accepting new connections, all active sessions are closed and the function returns after
+// all requests are finished or the shutdown timeout is reached.
+func Run(ctx context.Context, config Config, handler http.Handler) error {
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+	defer cancelBaseCtx()
+
+	srv := &http.Server{
+		Addr:              config.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		BaseContext: func(_ net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return fmt.Errorf("TLS requires a certificate and a key")
+	}
+
+	useTLS := config.TLSCertFile != "" && config.TLSKeyFile != ""
+
+	if useTLS {
+		tlsConfig, err := newTLSConfig(baseCtx, config)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = tlsConfig
+	} else if config.TLSClientCAFile != "" {
+		return fmt.Errorf("client certificate verification requires a TLS certificate and key")
+	}
+
+	go reap(baseCtx, reapInterval)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		log.WithFields(log.Fields{"address": config.Address, "tls": useTLS}).Infof("Start server")
+
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("Shutdown server")
+
+	// Cancel the context of all active requests, so that long running requests like log and watch streams are
+	// returning. Then we close all terminal and port forwarding sessions, before we wait for all requests to finish.
+	cancelBaseCtx()
+	terminal.TerminalSessions.CloseAll(1, "Server was stopped")
+	terminal.LogSessions.DeleteAll()
+	watch.Sessions.DeleteAll()
+	portforwarding.Sessions.CloseAll()
+
+	shutdownCtx, cancelShutdownCtx := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelShutdownCtx()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	return <-errCh
+}
+
+// reap removes all expired terminal, log and watch sessions and all failed port forwarding sessions in the given
+// interval, until the context is canceled. The timeouts for the terminal, log and watch sessions are configured via
+// terminal.SetSessionConfig.
+func reap(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			terminal.TerminalSessions.Reap()
+			terminal.LogSessions.Reap()
+			watch.Sessions.Reap(terminal.GetSessionConfig().BindTimeout)
+			portforwarding.Sessions.Reap(failedPortForwardingTimeout)
+		}
+	}
+}
+
+// newTLSConfig returns the TLS configuration for the server. The certificate is loaded via a certificateReloader, so
+// that a renewed certificate is used without restarting kubenav.
+func newTLSConfig(ctx context.Context, config Config) (*tls.Config, error) {
+	reloader, err := newCertificateReloader(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.CertReloadPeriod > 0 {
+		go reloader.watch(ctx, config.CertReloadPeriod)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if config.TLSClientCAFile != "" {
+		clientCA, err := ioutil.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(clientCA) {
+			return nil, fmt.Errorf("no certs found in client CA file")
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// certificateReloader holds the current TLS certificate of the server and reloads it, when the certificate or key file
+// is modified.
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modTime     time.Time
+	lock        sync.RWMutex
+}
+
+// newCertificateReloader returns a new certificateReloader and loads the certificate from the given files.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// getCertificate returns the current certificate. It is used as GetCertificate function in the TLS configuration.
+func (cr *certificateReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+	return cr.certificate, nil
+}
+
+// watch checks the modification time of the certificate and key file in the given interval and reloads the certificate
+// when one of the files was modified. If the new certificate can not be loaded, the old certificate is used.
+func (cr *certificateReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := cr.latestModTime()
+			if err != nil {
+				log.WithError(err).Errorf("Could not check TLS certificate")
+				continue
+			}
+
+			cr.lock.RLock()
+			changed := modTime.After(cr.modTime)
+			cr.lock.RUnlock()
+
+			if changed {
+				if err := cr.reload(); err != nil {
+					log.WithError(err).Errorf("Could not reload TLS certificate")
+					continue
+				}
+
+				log.Infof("TLS certificate was reloaded")
+			}
+		}
+	}
+}
+
+// reload loads the certificate and key from the configured files.
+func (cr *certificateReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.certificate = &certificate
+	cr.modTime = modTime
+
+	return nil
+}
+
+// latestModTime returns the latest modification time of the certificate and key file.
+func (cr *certificateReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}